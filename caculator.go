@@ -1,99 +1,338 @@
 package perf
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sort"
-	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var ErrTimeout = errors.New("timeout")
+
 type Calculator struct {
-	Name         string
-	Total        int
-	Used         time.Duration
-	Min          int64
-	Max          int64
-	Avg          int64
-	Success      int64
-	Failed       int64
-	FailedErrors map[string]int
-	Cost         []int64 `json:"-"`
-	tp           map[int]int64
-	percents     []int
-	result       string
+	Name            string
+	Begin           time.Time
+	Total           int
+	Used            time.Duration
+	Min             int64
+	Max             int64
+	Avg             int64
+	Success         int64
+	Failed          int64
+	FailedErrors    map[string]int
+	Cost            []int64    `json:"-"`
+	Uncorrected     []int64    `json:"-"`
+	Hist            *Histogram `json:"-"`
+	UncorrectedHist *Histogram `json:"-"`
+	Stages          []*Calculator
+	Operations      []*Calculator
+	Intervals       []*Interval
+	SetupUsed       time.Duration
+	SetupFailed     int
+	SetupErrors     map[string]int
+	TeardownErrors  map[string]int
+	Env             *Environment
+	tp              map[int]int64
+	utp             map[int]int64
+	percents        []int
+	options         BenchmarkOptions
+	result          string
+
+	// beforeRun and afterRun bracket the measured phase, after Setup and
+	// before Teardown.
+	beforeRun func()
+	afterRun  func()
+}
+
+type BenchmarkOptions struct {
+	Concurrent int
+	Times      int
+	Duration   time.Duration
+	Percents   []int
+
+	// Rate > 0 switches to open-loop: calls are issued at Rate per second
+	// and Cost is measured from the intended send time.
+	Rate    float64
+	Arrival Arrival
+
+	// Stages run one after another, each for its own Duration. When any
+	// stage sets a Rate the run is open-loop and Stage.Concurrent is the
+	// worker pool size (opt.Concurrent if zero). With Interpolate, targets
+	// move linearly from the previous stage's values instead of stepping.
+	Stages      []Stage
+	Interpolate bool
+
+	// Timeout bounds each call. Calls running longer are counted as failed
+	// with ErrTimeout, whether or not the executor honored its context.
+	Timeout time.Duration
+
+	// Histogram records latencies into histograms of HistogramDigits
+	// significant digits (DefaultHistogramDigits if zero) instead of keeping
+	// every sample in Cost, so memory stays constant however long the run.
+	Histogram       bool
+	HistogramDigits int
+
+	// Interval > 0 records throughput and latency per Interval into
+	// Calculator.Intervals.
+	Interval time.Duration
+
+	// Progress is called every Interval (or every second if Interval is
+	// zero) while the benchmark runs, and once more when it is done.
+	Progress func(p *Progress)
 }
 
 func (c *Calculator) Warmup(concurrent, times int, executor func() error) {
-	c.benchmark(concurrent, times, func(cnt int) {
+	newRunner(context.Background(), BenchmarkOptions{Concurrent: concurrent, Times: times}, func(worker, cnt, stage int, intended time.Time) {
 		executor()
-	})
+	}).run()
+}
+
+func (c *Calculator) WarmupDuration(concurrent int, duration time.Duration, executor func() error) {
+	newRunner(context.Background(), BenchmarkOptions{Concurrent: concurrent, Duration: duration}, func(worker, cnt, stage int, intended time.Time) {
+		executor()
+	}).run()
 }
 
 func (c *Calculator) Benchmark(concurrent, times int, executor func() error, percents []int) {
-	c.Total = times
-	begin := time.Now()
-	mux := sync.Mutex{}
-	c.FailedErrors = map[string]int{}
-	if len(percents) > 0 {
-		c.Cost = make([]int64, times)
-		c.benchmark(concurrent, times, func(cnt int) {
-			idx := cnt - 1
-			t := time.Now()
-			err := executor()
-			if err != nil {
-				atomic.AddInt64(&c.Failed, 1)
-				c.Cost[idx] = -1
-				mux.Lock()
-				errStr := err.Error()
-				errCnt := c.FailedErrors[errStr]
-				c.FailedErrors[errStr] = errCnt + 1
-				mux.Unlock()
-			} else {
-				c.Cost[idx] = time.Since(t).Nanoseconds()
-				atomic.AddInt64(&c.Success, 1)
-			}
-		})
-	} else {
-		c.benchmark(concurrent, times, func(cnt int) {
-			err := executor()
-			if err != nil {
-				atomic.AddInt64(&c.Failed, 1)
-				mux.Lock()
-				errStr := err.Error()
-				errCnt := c.FailedErrors[errStr]
-				c.FailedErrors[errStr] = errCnt + 1
-				mux.Unlock()
-			} else {
-				atomic.AddInt64(&c.Success, 1)
-			}
-		})
-	}
-	c.Used = time.Since(begin)
-	c.calculate(percents)
+	c.BenchmarkWithOptions(BenchmarkOptions{
+		Concurrent: concurrent,
+		Times:      times,
+		Percents:   percents,
+	}, executor)
+}
+
+func (c *Calculator) BenchmarkDuration(concurrent int, duration time.Duration, executor func() error, percents []int) {
+	c.BenchmarkWithOptions(BenchmarkOptions{
+		Concurrent: concurrent,
+		Duration:   duration,
+		Percents:   percents,
+	}, executor)
 }
 
-func (c *Calculator) benchmark(concurrent, times int, executor func(cnt int)) {
+func (c *Calculator) BenchmarkStages(stages []Stage, executor func() error, percents []int) {
+	c.BenchmarkWithOptions(BenchmarkOptions{
+		Stages:   stages,
+		Percents: percents,
+	}, executor)
+}
+
+func (c *Calculator) BenchmarkContext(ctx context.Context, concurrent, times int, executor func(ctx context.Context) error, percents []int) {
+	c.BenchmarkWithContext(ctx, BenchmarkOptions{
+		Concurrent: concurrent,
+		Times:      times,
+		Percents:   percents,
+	}, executor)
+}
+
+// BenchmarkWithOptions stops when opt.Times calls were made or opt.Duration
+// elapsed, whichever comes first. A zero Times or Duration means no limit.
+func (c *Calculator) BenchmarkWithOptions(opt BenchmarkOptions, executor func() error) {
+	c.BenchmarkWithContext(context.Background(), opt, func(ctx context.Context) error {
+		return executor()
+	})
+}
+
+// BenchmarkWithContext also stops when ctx is done and keeps the results
+// collected so far. Calls that fail because ctx was cancelled are dropped.
+// Without Times, Duration or Stages it runs until ctx is done.
+func (c *Calculator) BenchmarkWithContext(ctx context.Context, opt BenchmarkOptions, executor func(ctx context.Context) error) {
+	c.BenchmarkExecutor(ctx, opt, ExecutorFunc(func(ctx context.Context, worker, iteration int) error {
+		return executor(ctx)
+	}))
+}
+
+// BenchmarkExecutor runs executor.Setup for every worker before the clock
+// starts and executor.Teardown after it stops. Workers whose Setup fails
+// are left out of the run and counted in SetupFailed.
+func (c *Calculator) BenchmarkExecutor(ctx context.Context, opt BenchmarkOptions, executor Executor) {
+	c.benchmark(ctx, opt, executor, nil)
+}
+
+func (c *Calculator) benchmark(ctx context.Context, opt BenchmarkOptions, executor Executor, ops *picker) {
+	if opt.Concurrent <= 0 {
+		opt.Concurrent = 1
+	}
+
+	workers := opt.maxConcurrent()
+
+	// captured before the clock starts, the first call runs git.
+	c.Env = CaptureEnvironment()
+	c.SetupUsed = 0
+	c.SetupFailed = 0
+	c.SetupErrors = nil
+	c.TeardownErrors = nil
+	var disabled []bool
+	if hasSetup(executor) {
+		disabled = c.setup(executor, workers)
+		defer c.teardown(executor, disabled)
+	}
+
+	nstats := len(opt.Stages)
+	if nstats == 0 {
+		nstats = 1
+	}
+	if ops != nil {
+		nstats += len(ops.scenario.Operations)
+	}
+	shards := histogramShards(workers, nstats)
+
+	var all *stats
+	stages := make([]*stats, len(opt.Stages))
+	for i := range stages {
+		stages[i] = newStats(opt, workers, 0, shards)
+	}
+	if len(stages) == 0 {
+		all = newStats(opt, workers, opt.Times, shards)
+	}
+
+	var opStats []*stats
+	if ops != nil {
+		opStats = make([]*stats, len(ops.scenario.Operations))
+		for i := range opStats {
+			opStats[i] = newStats(opt, workers, 0, shards)
+		}
+	}
+
+	var tl *timeline
+	if opt.Interval > 0 {
+		tl = newTimeline(opt.Interval, workers)
+	} else if opt.Progress != nil {
+		tl = newTimeline(time.Second, workers)
+	}
 	var (
-		total uint64
-		wg    sync.WaitGroup
+		begin time.Time
+		r     *runner
 	)
+	if opt.Progress != nil {
+		tl.onInterval = func(iv *Interval, success, failed int64) {
+			// once the run stopped only the Done event below is reported.
+			if atomic.LoadInt32(&r.stopped) != 0 {
+				return
+			}
+			elapsed := time.Since(begin)
+			opt.Progress(&Progress{
+				Name:    c.Name,
+				Elapsed: elapsed,
+				Success: success,
+				Failed:  failed,
+				TPS:     iv.TPS,
+				TP99:    iv.TP99,
+				ETA:     opt.eta(elapsed, success+failed),
+			})
+		}
+	}
+
+	r = newRunner(ctx, opt, func(worker, cnt, stage int, intended time.Time) {
+		op, do := -1, executor
+		if ops != nil {
+			op = ops.pick(worker)
+			do = ops.scenario.Operations[op].Executor
+		}
+		begin := time.Now()
+		err := call(ctx, opt.Timeout, func(ctx context.Context) error {
+			return do.Do(ctx, worker, cnt)
+		})
+		end := time.Now()
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		if all != nil {
+			all.add(worker, begin, end, intended, err)
+		} else {
+			stages[stage].add(worker, begin, end, intended, err)
+		}
+		if op >= 0 {
+			opStats[op].add(worker, begin, end, intended, err)
+		}
+		if tl != nil {
+			if !intended.IsZero() {
+				begin = intended
+			}
+			tl.add(worker, end.Sub(begin).Nanoseconds(), err)
+		}
+	})
+
+	r.disabled = disabled
+
+	c.options = opt
+	if c.beforeRun != nil {
+		c.beforeRun()
+	}
+	// the clock starts after beforeRun, so its own cost is not measured.
+	begin = time.Now()
+	c.Begin = begin
+	if tl != nil {
+		tl.start()
+	}
+	r.run()
+	used := time.Since(begin)
+	if c.afterRun != nil {
+		c.afterRun()
+	}
+	c.Intervals = nil
+	if tl != nil {
+		tl.stop()
+		if opt.Interval > 0 {
+			c.Intervals = tl.intervals
+		}
+		if opt.Progress != nil {
+			p := &Progress{
+				Name:    c.Name,
+				Elapsed: used,
+				Success: atomic.LoadInt64(&tl.success),
+				Failed:  atomic.LoadInt64(&tl.failed),
+				Done:    true,
+			}
+			if n := len(tl.intervals); n > 0 {
+				p.TPS = tl.intervals[n-1].TPS
+				p.TP99 = tl.intervals[n-1].TP99
+			}
+			opt.Progress(p)
+		}
+	}
 
-	for i := 0; i < concurrent; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for {
-				cnt := int(atomic.AddUint64(&total, 1))
-				if cnt > times {
-					break
-				}
-				executor(cnt)
+	c.Stages = nil
+	if len(stages) > 0 {
+		for i, s := range stages {
+			sc := NewCalculator(fmt.Sprintf("%v-stage-%d", c.Name, i+1))
+			sc.Begin = r.windows[i].begin
+			sc.options = BenchmarkOptions{
+				Concurrent: opt.Stages[i].Concurrent,
+				Rate:       opt.Stages[i].Rate,
+				Duration:   opt.Stages[i].Duration,
+				Percents:   opt.Percents,
 			}
-		}()
+			s.flush(sc, r.windows[i].used(), opt.Percents)
+			c.Stages = append(c.Stages, sc)
+		}
+		all = mergeStats(stages)
 	}
+	all.flush(c, used, opt.Percents)
+
+	c.Operations = nil
+	for i, s := range opStats {
+		oc := NewCalculator(ops.scenario.Operations[i].Name)
+		oc.Begin = begin
+		oc.options = opt
+		s.flush(oc, used, opt.Percents)
+		c.Operations = append(c.Operations, oc)
+	}
+}
 
-	wg.Wait()
+func call(ctx context.Context, timeout time.Duration, executor func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return executor(ctx)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	begin := time.Now()
+	err := executor(callCtx)
+	if ctx.Err() == nil && (time.Since(begin) > timeout || errors.Is(err, context.DeadlineExceeded)) {
+		return ErrTimeout
+	}
+	return err
 }
 
 func (c *Calculator) calculate(percents []int) {
@@ -103,31 +342,37 @@ func (c *Calculator) calculate(percents []int) {
 		if v < 0 {
 			v = 0
 		}
-		if v > 100 {
-			v = 100
-		}
 		c.percents[i] = v
 	}
 
-	var min, max int64
-	sort.Slice(c.Cost, func(i, j int) bool {
-		if (min == 0 && c.Cost[i] > 0) || (c.Cost[i] > 0 && c.Cost[i] < min) {
-			min = c.Cost[i]
-		}
-		if (min == 0 && c.Cost[j] > 0) || (c.Cost[j] > 0 && c.Cost[j] < min) {
-			min = c.Cost[j]
-		}
-		if c.Cost[i] > max {
-			max = c.Cost[i]
-		}
-		if c.Cost[j] > max {
-			max = c.Cost[j]
+	c.utp = map[int]int64{}
+
+	if c.Hist != nil {
+		c.Min = c.Hist.Min()
+		c.Max = c.Hist.Max()
+		c.Avg = c.Hist.Mean()
+		for _, k := range c.percents {
+			c.tp[k] = c.TPN(k)
+			if c.UncorrectedHist != nil {
+				c.utp[k] = c.TPNUncorrected(k)
+			}
 		}
-		if c.Cost[i] < 0 {
-			return false
+		return
+	}
+
+	sortCost(c.Cost)
+
+	var min, max int64
+	for _, v := range c.Cost {
+		if v > 0 {
+			if min == 0 || v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
 		}
-		return c.Cost[i] < c.Cost[j]
-	})
+	}
 
 	c.Min = min
 	c.Max = max
@@ -143,9 +388,19 @@ func (c *Calculator) calculate(percents []int) {
 	for _, k := range c.percents {
 		c.tp[k] = c.TPN(k)
 	}
+
+	if len(c.Uncorrected) > 0 {
+		sortCost(c.Uncorrected)
+		for _, k := range c.percents {
+			c.utp[k] = c.TPNUncorrected(k)
+		}
+	}
 }
 
 func (c *Calculator) TPS() int64 {
+	if c.Used <= 0 {
+		return 0
+	}
 	return int64(float64(c.Success) / c.Used.Seconds())
 }
 
@@ -156,11 +411,33 @@ func (c *Calculator) TPN(percent int) int64 {
 	if v, ok := c.tp[percent]; ok {
 		return v
 	}
-	cost := TPNFrom(c.Cost, percent, true)
+	var cost int64
+	if c.Hist != nil {
+		cost = c.Hist.TPN(percent)
+	} else {
+		cost = TPNFrom(c.Cost, percent, true)
+	}
 	c.tp[percent] = cost
 	return cost
 }
 
+func (c *Calculator) TPNUncorrected(percent int) int64 {
+	if c.utp == nil {
+		c.utp = map[int]int64{}
+	}
+	if v, ok := c.utp[percent]; ok {
+		return v
+	}
+	var cost int64
+	if c.UncorrectedHist != nil {
+		cost = c.UncorrectedHist.TPN(percent)
+	} else {
+		cost = TPNFrom(c.Uncorrected, percent, true)
+	}
+	c.utp[percent] = cost
+	return cost
+}
+
 func (c *Calculator) String() string {
 	if c.result != "" {
 		return c.result
@@ -175,14 +452,18 @@ MIN USED : %v
 AVG USED : %v
 MAX USED : %v`,
 		c.Total,
-		c.Success, float64(c.Success)/float64(len(c.Cost))*100.0,
-		c.Failed, float64(c.Failed)/float64(len(c.Cost))*100.0,
+		c.Success, c.percentOfTotal(c.Success),
+		c.Failed, c.percentOfTotal(c.Failed),
 		c.TPS(),
 		I2TimeString(int64(c.Used)),
 		I2TimeString(c.Min),
 		I2TimeString(c.Avg),
 		I2TimeString(c.Max))
 
+	if c.SetupUsed > 0 || c.SetupFailed > 0 {
+		s += fmt.Sprintf("\nSETUP    : %v, %v failed", I2TimeString(int64(c.SetupUsed)), c.SetupFailed)
+	}
+
 	l := len("BENCHMARK")
 	for _, k := range c.percents {
 		tp := fmt.Sprintf("TP%v", k)
@@ -190,6 +471,9 @@ MAX USED : %v`,
 			tp += " "
 		}
 		s += fmt.Sprintf("\n%v: %v", tp, I2TimeString(c.tp[k]))
+		if len(c.utp) > 0 {
+			s += fmt.Sprintf(" (uncorrected: %v)", I2TimeString(c.utp[k]))
+		}
 	}
 
 	c.result = s
@@ -197,13 +481,12 @@ MAX USED : %v`,
 	return s
 }
 
-// func (c *Calculator) Json() string {
-// 	b, err := json.MarshalIndent(c .Cost, "", "  ")
-// 	if err != nil {
-// 		return erc .Error()
-// 	}
-// 	return string(b)
-// }
+func (c *Calculator) percentOfTotal(n int64) float64 {
+	if c.Total == 0 {
+		return 0
+	}
+	return float64(n) / float64(c.Total) * 100.0
+}
 
 func NewCalculator(name string) *Calculator {
 	return &Calculator{Name: name}
@@ -268,14 +551,27 @@ func Sum(cost []int64) int64 {
 }
 
 func TPNFrom(cost []int64, percent int, args ...interface{}) int64 {
+	return TPNFromBase(cost, percent, percentBase(percent), args...)
+}
+
+// percentBase is 100 up to TP100, then one more digit per digit, so 999 is
+// out of 1000.
+func percentBase(percent int) int {
 	base := 100
+	if percent <= 100 {
+		return base
+	}
 	shift := percent / 100
 	for shift > 0 {
 		base *= 10
 		shift /= 10
 	}
+	return base
+}
 
-	return TPNFromBase(cost, percent, base, args...)
+// percentile converts a TPN percent such as 99 or 999 to 99.0 or 99.9.
+func percentile(percent int) float64 {
+	return float64(percent) / float64(percentBase(percent)) * 100
 }
 
 func TPNFromBase(cost []int64, percent, base int, args ...interface{}) int64 {
@@ -288,12 +584,7 @@ func TPNFromBase(cost []int64, percent, base int, args ...interface{}) int64 {
 	}
 
 	if !sorted {
-		sort.Slice(cost, func(i, j int) bool {
-			if cost[i] < 0 {
-				return false
-			}
-			return cost[i] < cost[j]
-		})
+		sortCost(cost)
 	}
 
 	for i, v := range cost {
@@ -303,6 +594,10 @@ func TPNFromBase(cost []int64, percent, base int, args ...interface{}) int64 {
 		}
 	}
 
+	if len(cost) == 0 {
+		return 0
+	}
+
 	idx := int(float64(percent) / float64(base) * float64(len(cost)))
 	if idx >= len(cost) {
 		idx = len(cost) - 1
@@ -311,6 +606,19 @@ func TPNFromBase(cost []int64, percent, base int, args ...interface{}) int64 {
 	return cost[idx]
 }
 
+// sortCost sorts ascending and moves failed calls (-1) to the end.
+func sortCost(cost []int64) {
+	sort.Slice(cost, func(i, j int) bool {
+		if cost[j] < 0 {
+			return cost[i] >= 0
+		}
+		if cost[i] < 0 {
+			return false
+		}
+		return cost[i] < cost[j]
+	})
+}
+
 func I2TimeString(i int64) string {
 	used := float64(i) / float64(1e9)
 	usedStr := fmt.Sprintf("%.2fs", used)
@@ -0,0 +1,41 @@
+package perf
+
+import (
+	"testing"
+)
+
+func TestTPN(t *testing.T) {
+	cost := make([]int64, 10000)
+	h := NewHistogram(DefaultHistogramMax, 3)
+	for i := range cost {
+		cost[i] = int64(len(cost) - i)
+		h.Record(int64(i + 1))
+	}
+	for _, c := range []struct {
+		percent int
+		want    int64
+	}{
+		{50, 5001},
+		{99, 9901},
+		{100, 10000},
+		{999, 9991},
+		{9999, 10000},
+	} {
+		if got := TPNFrom(cost, c.percent); got != c.want {
+			t.Fatalf("TPNFrom TP%v is %v, want %v", c.percent, got, c.want)
+		}
+		// the histogram ranks to the nearest sample and is 3 digits precise.
+		if got := h.TPN(c.percent); got < c.want-10 || got > c.want+10 {
+			t.Fatalf("Histogram TP%v is %v, want about %v", c.percent, got, c.want)
+		}
+	}
+	if got := h.TPN(100); got != h.Max() {
+		t.Fatalf("Histogram TP100 is %v, want max %v", got, h.Max())
+	}
+	if p := percentile(100); p != 100 {
+		t.Fatalf("percentile(100) is %v", p)
+	}
+	if p := percentile(999); p != 99.9 {
+		t.Fatalf("percentile(999) is %v", p)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lesismal/perf"
+)
+
+func main() {
+	alpha := flag.Float64("alpha", perf.DefaultAlpha, "significance level")
+	asJson := flag.Bool("json", false, "print comparisons as json")
+	verbose := flag.Bool("v", false, "print every comparison in detail")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: perfcmp [flags] base.json target.json [target.json...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var results []*perf.Result
+	for _, path := range flag.Args() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		r, err := perf.ParseResult(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", path, err)
+			os.Exit(1)
+		}
+		results = append(results, r)
+	}
+
+	cmps := perf.CompareAll(results, *alpha)
+	if *asJson {
+		b, err := json.MarshalIndent(cmps, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(b))
+		return
+	}
+
+	if *verbose {
+		for _, c := range cmps {
+			fmt.Println(c.String())
+			fmt.Println("-------------------------")
+		}
+	}
+	fmt.Print(perf.ComparisonTable(cmps).Markdown())
+}
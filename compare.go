@@ -0,0 +1,253 @@
+package perf
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const DefaultAlpha = 0.05
+
+type Delta struct {
+	Base   int64   `json:"base"`
+	Target int64   `json:"target"`
+	Diff   int64   `json:"diff"`
+	Change float64 `json:"change"`
+}
+
+func newDelta(base, target int64) Delta {
+	d := Delta{Base: base, Target: target, Diff: target - base}
+	if base != 0 {
+		d.Change = float64(d.Diff) / float64(base) * 100
+	}
+	return d
+}
+
+type PercentileDelta struct {
+	Percent int `json:"percent"`
+	Delta
+}
+
+// MannWhitney is the result of a two-sided Mann-Whitney U test. Effect is
+// the rank-biserial correlation: positive when the target tends to be
+// larger than the base, in [-1, 1].
+type MannWhitney struct {
+	U      float64 `json:"u"`
+	Z      float64 `json:"z"`
+	P      float64 `json:"p"`
+	Effect float64 `json:"effect"`
+}
+
+type Comparison struct {
+	Base        string            `json:"base"`
+	Target      string            `json:"target"`
+	Alpha       float64           `json:"alpha"`
+	TPS         Delta             `json:"tps"`
+	Percentiles []PercentileDelta `json:"percentiles"`
+	// Latency compares the latency distributions, TPSTest the per-interval
+	// throughput of both runs; either is nil when the data is missing.
+	// Latency treats every call as independent, so with millions of calls
+	// any difference is "significant"; its P is information only.
+	Latency     *MannWhitney `json:"latency,omitempty"`
+	TPSTest     *MannWhitney `json:"tps_test,omitempty"`
+	Significant bool         `json:"significant"`
+}
+
+// Compare compares target against base. A difference is reported as
+// significant when the per-interval TPS test's p-value is below alpha
+// (DefaultAlpha if zero), so it has to stand out from the run's own
+// variance. Runs with fewer than two intervals are never significant.
+func Compare(base, target *Result, alpha float64) *Comparison {
+	if alpha <= 0 {
+		alpha = DefaultAlpha
+	}
+	cmp := &Comparison{
+		Base:   base.Name,
+		Target: target.Name,
+		Alpha:  alpha,
+		TPS:    newDelta(base.TPS, target.TPS),
+	}
+
+	bc, tc := base.Calculator(), target.Calculator()
+	for _, p := range base.Percentiles {
+		cmp.Percentiles = append(cmp.Percentiles, PercentileDelta{
+			Percent: p.Percent,
+			Delta:   newDelta(bc.TPN(p.Percent), tc.TPN(p.Percent)),
+		})
+	}
+
+	if base.Histogram != nil && target.Histogram != nil {
+		cmp.Latency = mannWhitney(histogramSamples(base.Histogram), histogramSamples(target.Histogram))
+	}
+	if len(base.Intervals) > 1 && len(target.Intervals) > 1 {
+		cmp.TPSTest = mannWhitney(intervalSamples(base.Intervals), intervalSamples(target.Intervals))
+		if cmp.TPSTest != nil && cmp.TPSTest.P < alpha {
+			cmp.Significant = true
+		}
+	}
+	return cmp
+}
+
+// CompareAll compares every result against the first one.
+func CompareAll(results []*Result, alpha float64) []*Comparison {
+	var cmps []*Comparison
+	for i := 1; i < len(results); i++ {
+		cmps = append(cmps, Compare(results[0], results[i], alpha))
+	}
+	return cmps
+}
+
+func (c *Comparison) String() string {
+	s := fmt.Sprintf("%v vs %v\nTPS      : %v -> %v (%+.2f%%)", c.Base, c.Target, c.TPS.Base, c.TPS.Target, c.TPS.Change)
+	if c.TPSTest != nil {
+		s += fmt.Sprintf(", p=%.4f%v", c.TPSTest.P, c.mark(c.TPSTest))
+	}
+	l := len("BENCHMARK")
+	for _, p := range c.Percentiles {
+		tp := fmt.Sprintf("TP%v", p.Percent)
+		for len(tp) < l {
+			tp += " "
+		}
+		s += fmt.Sprintf("\n%v: %v -> %v (%+.2f%%)", tp, I2TimeString(p.Base), I2TimeString(p.Target), p.Change)
+	}
+	if c.Latency != nil {
+		s += fmt.Sprintf("\nLATENCY  : p=%.4f, effect=%+.3f (per call, info only)", c.Latency.P, c.Latency.Effect)
+	}
+	return s
+}
+
+func (c *Comparison) mark(t *MannWhitney) string {
+	if t.P < c.Alpha {
+		return " (significant)"
+	}
+	return " (noise)"
+}
+
+// ComparisonTable renders one row for the baseline and one per comparison.
+// A significant TPS difference is marked with "*", P(latency) is never
+// marked since it is information only.
+func ComparisonTable(cmps []*Comparison) *Table {
+	t := NewTable()
+	if len(cmps) == 0 {
+		return t
+	}
+
+	title := []string{"Name", "TPS"}
+	for _, p := range cmps[0].Percentiles {
+		title = append(title, fmt.Sprintf("TP%v", p.Percent))
+	}
+	title = append(title, "P(latency)", "P(TPS)")
+	t.SetTitle(title)
+
+	row := []string{cmps[0].Base, fmt.Sprintf("%v", cmps[0].TPS.Base)}
+	for _, p := range cmps[0].Percentiles {
+		row = append(row, I2TimeString(p.Base))
+	}
+	t.AddRow(append(row, "-", "-"))
+
+	for _, c := range cmps {
+		row := []string{c.Target, fmt.Sprintf("%v (%+.1f%%)", c.TPS.Target, c.TPS.Change)}
+		for _, p := range c.Percentiles {
+			row = append(row, fmt.Sprintf("%v (%+.1f%%)", I2TimeString(p.Target), p.Change))
+		}
+		latency := "-"
+		if c.Latency != nil {
+			latency = fmt.Sprintf("%.4f", c.Latency.P)
+		}
+		row = append(row, latency, c.pValue(c.TPSTest))
+		t.AddRow(row)
+	}
+	return t
+}
+
+func (c *Comparison) pValue(t *MannWhitney) string {
+	if t == nil {
+		return "-"
+	}
+	s := fmt.Sprintf("%.4f", t.P)
+	if t.P < c.Alpha {
+		s += "*"
+	}
+	return s
+}
+
+type sample struct {
+	value float64
+	count float64
+}
+
+func histogramSamples(h *Histogram) []sample {
+	var samples []sample
+	h.ForEach(func(value, count int64) {
+		samples = append(samples, sample{float64(value), float64(count)})
+	})
+	return samples
+}
+
+func intervalSamples(intervals []*Interval) []sample {
+	samples := make([]sample, len(intervals))
+	for i, iv := range intervals {
+		samples[i] = sample{float64(iv.TPS), 1}
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].value < samples[j].value
+	})
+	return samples
+}
+
+// mannWhitney runs the test on two sorted, weighted samples. Equal values
+// are ties and get their average rank, with the usual tie correction.
+func mannWhitney(a, b []sample) *MannWhitney {
+	var n1, n2 float64
+	for _, v := range a {
+		n1 += v.count
+	}
+	for _, v := range b {
+		n2 += v.count
+	}
+	if n1 == 0 || n2 == 0 {
+		return nil
+	}
+
+	var rank, r2, ties float64
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		var v, ca, cb float64
+		switch {
+		case j >= len(b) || (i < len(a) && a[i].value < b[j].value):
+			v = a[i].value
+		default:
+			v = b[j].value
+		}
+		for i < len(a) && a[i].value == v {
+			ca += a[i].count
+			i++
+		}
+		for j < len(b) && b[j].value == v {
+			cb += b[j].count
+			j++
+		}
+		t := ca + cb
+		r2 += cb * (rank + (t+1)/2)
+		rank += t
+		ties += t*t*t - t
+	}
+
+	n := n1 + n2
+	u := r2 - n2*(n2+1)/2
+	mu := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+
+	mw := &MannWhitney{U: u, Effect: 2*u/(n1*n2) - 1, P: 1}
+	if sigma > 0 {
+		d := u - mu
+		// continuity correction
+		if d > 0 {
+			d -= 0.5
+		} else if d < 0 {
+			d += 0.5
+		}
+		mw.Z = d / sigma
+		mw.P = math.Erfc(math.Abs(mw.Z) / math.Sqrt2)
+	}
+	return mw
+}
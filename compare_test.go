@@ -0,0 +1,137 @@
+package perf
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// weighted turns values into sorted samples, equal values folded into one
+// weighted sample.
+func weighted(values ...float64) []sample {
+	var samples []sample
+	for _, v := range values {
+		if n := len(samples); n > 0 && samples[n-1].value == v {
+			samples[n-1].count++
+			continue
+		}
+		samples = append(samples, sample{v, 1})
+	}
+	return samples
+}
+
+// unweighted is weighted without folding, every value is its own sample.
+func unweighted(values ...float64) []sample {
+	samples := make([]sample, len(values))
+	for i, v := range values {
+		samples[i] = sample{v, 1}
+	}
+	return samples
+}
+
+func checkMannWhitney(t *testing.T, name string, got *MannWhitney, u, z, p, effect float64) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("%v: nil result", name)
+	}
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-4
+	}
+	if !near(got.U, u) || !near(got.Z, z) || !near(got.P, p) || !near(got.Effect, effect) {
+		t.Fatalf("%v: U %v Z %v P %v effect %v, want %v %v %v %v", name, got.U, got.Z, got.P, got.Effect, u, z, p, effect)
+	}
+}
+
+func TestMannWhitney(t *testing.T) {
+	a := []float64{1, 2, 3, 4, 5}
+	b := []float64{6, 7, 8, 9, 10}
+	checkMannWhitney(t, "separated", mannWhitney(unweighted(a...), unweighted(b...)), 25, 2.50672, 0.01219, 1)
+	checkMannWhitney(t, "reversed", mannWhitney(unweighted(b...), unweighted(a...)), 0, -2.50672, 0.01219, -1)
+	checkMannWhitney(t, "same", mannWhitney(unweighted(a...), unweighted(a...)), 12.5, 0, 1, 0)
+
+	// expected values from the pairwise definition of U with the tie
+	// corrected variance.
+	a = []float64{1, 2, 2, 3, 3, 3, 4}
+	b = []float64{3, 3, 4, 4, 5, 5, 5, 6}
+	checkMannWhitney(t, "ties", mannWhitney(unweighted(a...), unweighted(b...)), 50, 2.55518, 0.01061, 0.78571)
+	checkMannWhitney(t, "ties weighted", mannWhitney(weighted(a...), weighted(b...)), 50, 2.55518, 0.01061, 0.78571)
+
+	a = []float64{1, 1, 1, 2, 2, 5}
+	b = []float64{1, 2, 2, 2, 5, 5, 5, 5}
+	checkMannWhitney(t, "heavy ties", mannWhitney(weighted(a...), weighted(b...)), 35.5, 1.50520, 0.13227, 0.47917)
+}
+
+func TestMannWhitneyDegenerate(t *testing.T) {
+	if mannWhitney(nil, weighted(1, 2)) != nil || mannWhitney(weighted(1), []sample{{1, 0}}) != nil {
+		t.Fatal("expected nil for an empty sample")
+	}
+	mw := mannWhitney(weighted(3, 3, 3), weighted(3, 3))
+	if mw.P != 1 || mw.Z != 0 {
+		t.Fatalf("all ties: P %v Z %v, want 1 0", mw.P, mw.Z)
+	}
+}
+
+func TestMannWhitneyHistogram(t *testing.T) {
+	base := NewHistogram(DefaultHistogramMax, 3)
+	target := NewHistogram(DefaultHistogramMax, 3)
+	var a, b []float64
+	for i := int64(1); i <= 20; i++ {
+		base.RecordN(i*1000, i)
+		target.RecordN(i*1000+500, 21-i)
+	}
+	base.ForEach(func(value, count int64) {
+		for ; count > 0; count-- {
+			a = append(a, float64(value))
+		}
+	})
+	target.ForEach(func(value, count int64) {
+		for ; count > 0; count-- {
+			b = append(b, float64(value))
+		}
+	})
+
+	got := mannWhitney(histogramSamples(base), histogramSamples(target))
+	want := mannWhitney(unweighted(a...), unweighted(b...))
+	checkMannWhitney(t, "histogram", got, want.U, want.Z, want.P, want.Effect)
+	if got.P >= DefaultAlpha || got.Effect >= 0 {
+		t.Fatalf("P %v effect %v, want a significant decrease", got.P, got.Effect)
+	}
+}
+
+func TestCompareSignificance(t *testing.T) {
+	result := func(name string, latency int64, tps ...int64) *Result {
+		h := NewHistogram(DefaultHistogramMax, 3)
+		r := &Result{Name: name, Histogram: h}
+		for _, v := range tps {
+			h.RecordN(latency, v)
+			r.Intervals = append(r.Intervals, &Interval{TPS: v})
+		}
+		return r
+	}
+
+	// a 1% latency shift over 800k calls is a tiny p-value, yet the
+	// per-interval TPS overlaps, so it is not significant.
+	base := result("base", 10000, 100000, 98000, 102000, 101000, 99000, 100000, 97000, 103000)
+	target := result("target", 10100, 99000, 101000, 100000, 98000, 102000, 100000, 103000, 97000)
+	cmp := Compare(base, target, 0)
+	if cmp.Latency == nil || cmp.Latency.P >= DefaultAlpha {
+		t.Fatalf("latency %+v, want a tiny per-call p-value", cmp.Latency)
+	}
+	if cmp.TPSTest == nil || cmp.Significant {
+		t.Fatalf("TPS test %+v, significant %v, want noise", cmp.TPSTest, cmp.Significant)
+	}
+	if s := ComparisonTable([]*Comparison{cmp}).Markdown(); strings.Contains(s, "*") {
+		t.Fatalf("marked as significant:\n%v", s)
+	}
+
+	target = result("target", 10100, 80000, 81000, 79000, 80000, 82000, 78000, 80000, 81000)
+	if cmp = Compare(base, target, 0); !cmp.Significant {
+		t.Fatalf("TPS test %+v, want significant", cmp.TPSTest)
+	}
+
+	// without intervals there is nothing to judge the run-to-run variance by.
+	base.Intervals, target.Intervals = nil, nil
+	if cmp = Compare(base, target, 0); cmp.TPSTest != nil || cmp.Significant {
+		t.Fatalf("TPS test %+v, significant %v without intervals", cmp.TPSTest, cmp.Significant)
+	}
+}
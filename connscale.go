@@ -0,0 +1,238 @@
+package perf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrConnDropped = errors.New("connection dropped")
+
+type ConnScaleOptions struct {
+	// Connections is the number of connections to open and hold.
+	Connections int
+	// Dial opens connection id (1 to Connections). It defaults to dialing
+	// Network (tcp if empty) and Addr.
+	Dial    func(ctx context.Context, id int) (net.Conn, error)
+	Network string
+	Addr    string
+	// DialRate limits new connections per second, unlimited if zero.
+	// DialConcurrent is the number of dials in flight, 100 if zero.
+	DialRate       float64
+	DialConcurrent int
+	DialTimeout    time.Duration
+
+	// Hold is how long all connections are held once dialing is done.
+	// During that time the first Active connections are pinged every
+	// PingInterval (a second if zero) while the rest stay idle. Ping
+	// defaults to writing "ping" and reading back 4 bytes, which suits an
+	// echo server.
+	Hold         time.Duration
+	Active       int
+	PingInterval time.Duration
+	Ping         func(ctx context.Context, conn net.Conn) error
+	PingTimeout  time.Duration
+
+	Percents []int
+
+	// PSCounter samples the target server, usually created with
+	// NewPSCounter(serverPid), from before the first dial until the
+	// connections are closed. Memory is always counted.
+	PSCounter      *PSCounter
+	PSCountOptions PSCountOptions
+}
+
+// ConnScale is the result of RunConnScale. Connect has the dial latencies
+// and failures, Ping the pings of the active connections during Hold.
+// MemPerConn is the growth of the target's RSS from before the first dial
+// to the end of Hold, divided by the connections held.
+type ConnScale struct {
+	Name       string
+	Connect    *Calculator
+	Ping       *Calculator
+	Connected  int
+	Dropped    int
+	BaseRSS    uint64
+	HoldRSS    uint64
+	PeakRSS    uint64
+	MemPerConn uint64
+	Env        *Environment
+}
+
+type scaleConn struct {
+	mux  sync.Mutex
+	conn net.Conn
+}
+
+// RunConnScale opens opt.Connections connections at opt.DialRate, holds
+// them for opt.Hold with opt.Active of them pinged periodically, then
+// closes them all. Holding many connections usually needs a raised open
+// files limit on both sides.
+func RunConnScale(ctx context.Context, name string, opt ConnScaleOptions) *ConnScale {
+	if opt.DialConcurrent <= 0 {
+		opt.DialConcurrent = 100
+	}
+	if opt.PingInterval <= 0 {
+		opt.PingInterval = time.Second
+	}
+	if opt.Active > opt.Connections {
+		opt.Active = opt.Connections
+	}
+	if opt.Dial == nil {
+		network := opt.Network
+		if network == "" {
+			network = "tcp"
+		}
+		d := &net.Dialer{}
+		opt.Dial = func(ctx context.Context, id int) (net.Conn, error) {
+			return d.DialContext(ctx, network, opt.Addr)
+		}
+	}
+	if opt.Ping == nil {
+		opt.Ping = echoPing
+	}
+
+	cs := &ConnScale{
+		Name:    name,
+		Connect: NewCalculator(name + "-connect"),
+		Env:     CaptureEnvironment(),
+	}
+
+	p := opt.PSCounter
+	if p != nil {
+		if stat, err := p.proc.MemoryInfo(); err == nil {
+			cs.BaseRSS = stat.RSS
+		}
+		popt := opt.PSCountOptions
+		popt.CountMEM = true
+		p.Start(popt)
+	}
+
+	conns := make([]scaleConn, opt.Connections)
+	defer func() {
+		for i := range conns {
+			if conns[i].conn != nil {
+				conns[i].conn.Close()
+			}
+		}
+	}()
+
+	cs.Connect.BenchmarkExecutor(ctx, BenchmarkOptions{
+		Concurrent: opt.DialConcurrent,
+		Times:      opt.Connections,
+		Rate:       opt.DialRate,
+		Timeout:    opt.DialTimeout,
+		Percents:   opt.Percents,
+		Histogram:  true,
+	}, ExecutorFunc(func(ctx context.Context, worker, iteration int) error {
+		conn, err := opt.Dial(ctx, iteration)
+		if err != nil {
+			return err
+		}
+		// a dial finishing after the timeout is counted as failed, so it
+		// must not be held either.
+		if ctx.Err() != nil {
+			conn.Close()
+			return ctx.Err()
+		}
+		conns[iteration-1].conn = conn
+		return nil
+	}))
+
+	var active []int
+	for i := range conns {
+		if conns[i].conn != nil {
+			cs.Connected++
+			if len(active) < opt.Active {
+				active = append(active, i)
+			}
+		}
+	}
+
+	if ctx.Err() == nil && opt.Hold > 0 {
+		if len(active) > 0 {
+			cs.Ping = NewCalculator(name + "-ping")
+			var mux sync.Mutex
+			cs.Ping.BenchmarkExecutor(ctx, BenchmarkOptions{
+				Concurrent: len(active),
+				Duration:   opt.Hold,
+				Rate:       float64(len(active)) / opt.PingInterval.Seconds(),
+				Timeout:    opt.PingTimeout,
+				Percents:   opt.Percents,
+				Histogram:  true,
+			}, ExecutorFunc(func(ctx context.Context, worker, iteration int) error {
+				sc := &conns[active[(iteration-1)%len(active)]]
+				sc.mux.Lock()
+				defer sc.mux.Unlock()
+				if sc.conn == nil {
+					return ErrConnDropped
+				}
+				err := opt.Ping(ctx, sc.conn)
+				if err != nil && ctx.Err() == nil {
+					sc.conn.Close()
+					sc.conn = nil
+					mux.Lock()
+					cs.Dropped++
+					mux.Unlock()
+				}
+				return err
+			}))
+		} else {
+			timer := time.NewTimer(opt.Hold)
+			select {
+			case <-ctx.Done():
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}
+
+	if p != nil {
+		if stat, err := p.proc.MemoryInfo(); err == nil {
+			cs.HoldRSS = stat.RSS
+		}
+		p.Stop()
+		cs.PeakRSS = p.MEMRSS().Max()
+		if cs.HoldRSS > cs.PeakRSS {
+			cs.PeakRSS = cs.HoldRSS
+		}
+		if held := cs.Connected - cs.Dropped; held > 0 && cs.HoldRSS > cs.BaseRSS {
+			cs.MemPerConn = (cs.HoldRSS - cs.BaseRSS) / uint64(held)
+		}
+	}
+	return cs
+}
+
+func (cs *ConnScale) String() string {
+	s := fmt.Sprintf("CONNECTED: %v, %v dropped\n[%v]\n%v", cs.Connected, cs.Dropped, cs.Connect.Name, cs.Connect.String())
+	if cs.Ping != nil {
+		s += fmt.Sprintf("\n[%v]\n%v", cs.Ping.Name, cs.Ping.String())
+	}
+	if cs.BaseRSS > 0 || cs.HoldRSS > 0 {
+		s += fmt.Sprintf("\nRSS      : %v -> %v, peak %v\nMEM/CONN : %v",
+			I2MemString(cs.BaseRSS), I2MemString(cs.HoldRSS), I2MemString(cs.PeakRSS), I2MemString(cs.MemPerConn))
+	}
+	return s
+}
+
+func echoPing(ctx context.Context, conn net.Conn) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return err
+	}
+	var buf [4]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		return err
+	}
+	if string(buf[:]) != "ping" {
+		return fmt.Errorf("unexpected pong: %q", buf[:])
+	}
+	return nil
+}
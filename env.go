@@ -0,0 +1,119 @@
+package perf
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/mem"
+)
+
+// Environment records where and how a result was produced. Fields that
+// could not be detected are left empty.
+type Environment struct {
+	Timestamp     time.Time `json:"timestamp"`
+	GoVersion     string    `json:"go_version"`
+	GOOS          string    `json:"goos"`
+	GOARCH        string    `json:"goarch"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	NumCPU        int       `json:"num_cpu"`
+	PhysicalCores int       `json:"physical_cores,omitempty"`
+	CPUModel      string    `json:"cpu_model,omitempty"`
+	Kernel        string    `json:"kernel,omitempty"`
+	TotalMemory   uint64    `json:"total_memory,omitempty"`
+	Hostname      string    `json:"hostname,omitempty"`
+	Module        string    `json:"module,omitempty"`
+	Commit        string    `json:"commit,omitempty"`
+	Modified      bool      `json:"modified,omitempty"`
+	Args          []string  `json:"args,omitempty"`
+}
+
+var (
+	envOnce   sync.Once
+	envStatic Environment
+)
+
+// CaptureEnvironment returns the current environment. Host details are
+// detected once per process, the timestamp, GOMAXPROCS and command line
+// are read on every call.
+func CaptureEnvironment() *Environment {
+	envOnce.Do(detectEnvironment)
+	env := envStatic
+	env.Timestamp = time.Now()
+	env.GOMAXPROCS = runtime.GOMAXPROCS(0)
+	env.Args = append([]string{}, os.Args...)
+	return &env
+}
+
+func detectEnvironment() {
+	env := &envStatic
+	env.GoVersion = runtime.Version()
+	env.GOOS = runtime.GOOS
+	env.GOARCH = runtime.GOARCH
+	env.NumCPU = runtime.NumCPU()
+	if n, err := cpu.Counts(false); err == nil {
+		env.PhysicalCores = n
+	}
+	if infos, err := cpu.Info(); err == nil && len(infos) > 0 {
+		env.CPUModel = infos[0].ModelName
+	}
+	if kernel, err := host.KernelVersion(); err == nil {
+		env.Kernel = kernel
+	}
+	if vm, err := mem.VirtualMemory(); err == nil {
+		env.TotalMemory = vm.Total
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		env.Hostname = hostname
+	}
+
+	// binaries built from a checkout carry the commit, "go run" and tests
+	// do not, so fall back to asking git about the working directory.
+	if info, ok := debug.ReadBuildInfo(); ok {
+		env.Module = info.Main.Path
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				env.Commit = s.Value
+			case "vcs.modified":
+				env.Modified = s.Value == "true"
+			}
+		}
+	}
+	if env.Commit == "" {
+		if out, err := exec.Command("git", "rev-parse", "HEAD").Output(); err == nil {
+			env.Commit = strings.TrimSpace(string(out))
+			if out, err := exec.Command("git", "status", "--porcelain", "--untracked-files=no").Output(); err == nil {
+				env.Modified = len(strings.TrimSpace(string(out))) > 0
+			}
+		}
+	}
+}
+
+func (env *Environment) String() string {
+	commit := env.Commit
+	if commit == "" {
+		commit = "unknown"
+	} else if env.Modified {
+		commit += " (modified)"
+	}
+	return fmt.Sprintf(`HOST     : %v, %v %v/%v
+CPU      : %v, %v cores, %v threads, GOMAXPROCS %v
+MEMORY   : %v
+GO       : %v
+COMMIT   : %v
+TIME     : %v`,
+		env.Hostname, env.Kernel, env.GOOS, env.GOARCH,
+		env.CPUModel, env.PhysicalCores, env.NumCPU, env.GOMAXPROCS,
+		I2MemString(env.TotalMemory),
+		env.GoVersion,
+		commit,
+		env.Timestamp.Format(time.RFC3339))
+}
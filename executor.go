@@ -0,0 +1,103 @@
+package perf
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Executor gives every worker its own state, such as a persistent
+// connection. Setup and Teardown are called once per worker, from
+// different goroutines, and are not measured. iteration is the run-wide
+// call sequence number, starting at 1.
+type Executor interface {
+	Setup(worker int) error
+	Do(ctx context.Context, worker, iteration int) error
+	Teardown(worker int) error
+}
+
+type ExecutorFunc func(ctx context.Context, worker, iteration int) error
+
+func (f ExecutorFunc) Setup(worker int) error {
+	return nil
+}
+
+func (f ExecutorFunc) Do(ctx context.Context, worker, iteration int) error {
+	return f(ctx, worker, iteration)
+}
+
+func (f ExecutorFunc) Teardown(worker int) error {
+	return nil
+}
+
+func hasSetup(executor Executor) bool {
+	switch v := executor.(type) {
+	case ExecutorFunc:
+		return false
+	case *Scenario:
+		for _, op := range v.Operations {
+			if hasSetup(op.Executor) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (c *Calculator) setup(executor Executor, workers int) []bool {
+	var (
+		wg       sync.WaitGroup
+		mux      sync.Mutex
+		disabled = make([]bool, workers)
+	)
+
+	c.SetupErrors = map[string]int{}
+	begin := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			err := executor.Setup(worker)
+			if err != nil {
+				mux.Lock()
+				disabled[worker] = true
+				c.SetupFailed++
+				errStr := err.Error()
+				errCnt := c.SetupErrors[errStr]
+				c.SetupErrors[errStr] = errCnt + 1
+				mux.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	c.SetupUsed = time.Since(begin)
+	return disabled
+}
+
+func (c *Calculator) teardown(executor Executor, disabled []bool) {
+	var (
+		wg  sync.WaitGroup
+		mux sync.Mutex
+	)
+
+	c.TeardownErrors = map[string]int{}
+	for i := range disabled {
+		if disabled[i] {
+			continue
+		}
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			err := executor.Teardown(worker)
+			if err != nil {
+				mux.Lock()
+				errStr := err.Error()
+				errCnt := c.TeardownErrors[errStr]
+				c.TeardownErrors[errStr] = errCnt + 1
+				mux.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
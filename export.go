@@ -0,0 +1,339 @@
+package perf
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RunInfo struct {
+	Begin      time.Time     `json:"begin"`
+	Concurrent int           `json:"concurrent,omitempty"`
+	Times      int           `json:"times,omitempty"`
+	Duration   time.Duration `json:"duration,omitempty"`
+	Rate       float64       `json:"rate,omitempty"`
+	Arrival    string        `json:"arrival,omitempty"`
+	Stages     []Stage       `json:"stages,omitempty"`
+	Timeout    time.Duration `json:"timeout,omitempty"`
+}
+
+type Percentile struct {
+	Percent     int   `json:"percent"`
+	Value       int64 `json:"value"`
+	Uncorrected int64 `json:"uncorrected,omitempty"`
+}
+
+// Result is the exported form of a Calculator. Durations and latencies are
+// in nanoseconds.
+type Result struct {
+	Name                 string         `json:"name"`
+	Run                  RunInfo        `json:"run"`
+	Total                int            `json:"total"`
+	Success              int64          `json:"success"`
+	Failed               int64          `json:"failed"`
+	Used                 time.Duration  `json:"used"`
+	TPS                  int64          `json:"tps"`
+	Min                  int64          `json:"min"`
+	Avg                  int64          `json:"avg"`
+	Max                  int64          `json:"max"`
+	Percentiles          []Percentile   `json:"percentiles,omitempty"`
+	FailedErrors         map[string]int `json:"failed_errors,omitempty"`
+	SetupUsed            time.Duration  `json:"setup_used,omitempty"`
+	SetupFailed          int            `json:"setup_failed,omitempty"`
+	SetupErrors          map[string]int `json:"setup_errors,omitempty"`
+	TeardownErrors       map[string]int `json:"teardown_errors,omitempty"`
+	Intervals            []*Interval    `json:"intervals,omitempty"`
+	Stages               []*Result      `json:"stages,omitempty"`
+	Operations           []*Result      `json:"operations,omitempty"`
+	Histogram            *Histogram     `json:"histogram,omitempty"`
+	UncorrectedHistogram *Histogram     `json:"uncorrected_histogram,omitempty"`
+	Env                  *Environment   `json:"env,omitempty"`
+}
+
+func (c *Calculator) Result() *Result {
+	r := &Result{
+		Name:           c.Name,
+		Total:          c.Total,
+		Success:        c.Success,
+		Failed:         c.Failed,
+		Used:           c.Used,
+		TPS:            c.TPS(),
+		Min:            c.Min,
+		Avg:            c.Avg,
+		Max:            c.Max,
+		FailedErrors:   c.FailedErrors,
+		SetupUsed:      c.SetupUsed,
+		SetupFailed:    c.SetupFailed,
+		SetupErrors:    c.SetupErrors,
+		TeardownErrors: c.TeardownErrors,
+		Intervals:      c.Intervals,
+		Env:            c.Env,
+		Run: RunInfo{
+			Begin:      c.Begin,
+			Concurrent: c.options.Concurrent,
+			Times:      c.options.Times,
+			Duration:   c.options.Duration,
+			Rate:       c.options.Rate,
+			Stages:     c.options.Stages,
+			Timeout:    c.options.Timeout,
+		},
+	}
+	if c.options.openLoop() {
+		r.Run.Arrival = c.options.Arrival.String()
+	}
+
+	for _, k := range c.percents {
+		p := Percentile{Percent: k, Value: c.TPN(k)}
+		if len(c.utp) > 0 {
+			p.Uncorrected = c.TPNUncorrected(k)
+		}
+		r.Percentiles = append(r.Percentiles, p)
+	}
+
+	r.Histogram = c.Hist
+	if r.Histogram == nil && len(c.Cost) > 0 {
+		r.Histogram = costHistogram(c.Cost)
+	}
+	r.UncorrectedHistogram = c.UncorrectedHist
+	if r.UncorrectedHistogram == nil && len(c.Uncorrected) > 0 {
+		r.UncorrectedHistogram = costHistogram(c.Uncorrected)
+	}
+
+	for _, s := range c.Stages {
+		r.Stages = append(r.Stages, s.Result())
+	}
+	for _, o := range c.Operations {
+		r.Operations = append(r.Operations, o.Result())
+	}
+	return r
+}
+
+func (c *Calculator) Json() string {
+	b, err := json.MarshalIndent(c.Result(), "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func (c *Calculator) CSV() string {
+	var sb strings.Builder
+	if err := WriteCSV(&sb, c.Result()); err != nil {
+		return err.Error()
+	}
+	return sb.String()
+}
+
+// Calculator rebuilds a Calculator from r. Cost is not restored, latency
+// queries are answered from the saved percentiles and histograms.
+func (r *Result) Calculator() *Calculator {
+	c := &Calculator{
+		Name:            r.Name,
+		Begin:           r.Run.Begin,
+		Total:           r.Total,
+		Used:            r.Used,
+		Min:             r.Min,
+		Max:             r.Max,
+		Avg:             r.Avg,
+		Success:         r.Success,
+		Failed:          r.Failed,
+		FailedErrors:    r.FailedErrors,
+		SetupUsed:       r.SetupUsed,
+		SetupFailed:     r.SetupFailed,
+		SetupErrors:     r.SetupErrors,
+		TeardownErrors:  r.TeardownErrors,
+		Hist:            r.Histogram,
+		UncorrectedHist: r.UncorrectedHistogram,
+		Intervals:       r.Intervals,
+		Env:             r.Env,
+		tp:              map[int]int64{},
+		utp:             map[int]int64{},
+		options: BenchmarkOptions{
+			Concurrent: r.Run.Concurrent,
+			Times:      r.Run.Times,
+			Duration:   r.Run.Duration,
+			Rate:       r.Run.Rate,
+			Arrival:    parseArrival(r.Run.Arrival),
+			Stages:     r.Run.Stages,
+			Timeout:    r.Run.Timeout,
+		},
+	}
+	if c.FailedErrors == nil {
+		c.FailedErrors = map[string]int{}
+	}
+	for _, p := range r.Percentiles {
+		c.percents = append(c.percents, p.Percent)
+		c.tp[p.Percent] = p.Value
+		if r.UncorrectedHistogram != nil || p.Uncorrected != 0 {
+			c.utp[p.Percent] = p.Uncorrected
+		}
+	}
+	c.options.Percents = c.percents
+	for _, s := range r.Stages {
+		c.Stages = append(c.Stages, s.Calculator())
+	}
+	for _, o := range r.Operations {
+		c.Operations = append(c.Operations, o.Calculator())
+	}
+	return c
+}
+
+func ParseResult(b []byte) (*Result, error) {
+	r := &Result{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func ReadResult(rd io.Reader) (*Result, error) {
+	r := &Result{}
+	if err := json.NewDecoder(rd).Decode(r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// WriteCSV writes one row per result, stage and operation. The percentile columns
+// are the union of all results' percentiles.
+func WriteCSV(w io.Writer, results ...*Result) error {
+	// stages and operations share their run's environment.
+	var rows []*Result
+	envs := map[*Result]*Environment{}
+	for _, r := range results {
+		rows = append(rows, r)
+		rows = append(rows, r.Stages...)
+		rows = append(rows, r.Operations...)
+		for _, s := range r.Stages {
+			envs[s] = r.Env
+		}
+		for _, o := range r.Operations {
+			envs[o] = r.Env
+		}
+	}
+
+	percents := map[int]bool{}
+	uncorrected := false
+	for _, r := range rows {
+		for _, p := range r.Percentiles {
+			percents[p.Percent] = true
+		}
+		if r.UncorrectedHistogram != nil {
+			uncorrected = true
+		}
+	}
+	cols := make([]int, 0, len(percents))
+	for k := range percents {
+		cols = append(cols, k)
+	}
+	sort.Ints(cols)
+
+	header := []string{"name", "begin", "concurrent", "total", "success", "failed", "used_ns", "tps", "min_ns", "avg_ns", "max_ns"}
+	for _, k := range cols {
+		header = append(header, fmt.Sprintf("tp%v_ns", k))
+		if uncorrected {
+			header = append(header, fmt.Sprintf("tp%v_uncorrected_ns", k))
+		}
+	}
+	header = append(header, "failed_errors", "hostname", "go_version", "gomaxprocs", "commit")
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		row := []string{
+			r.Name,
+			r.Run.Begin.Format(time.RFC3339Nano),
+			strconv.Itoa(r.Run.Concurrent),
+			strconv.Itoa(r.Total),
+			strconv.FormatInt(r.Success, 10),
+			strconv.FormatInt(r.Failed, 10),
+			strconv.FormatInt(int64(r.Used), 10),
+			strconv.FormatInt(r.TPS, 10),
+			strconv.FormatInt(r.Min, 10),
+			strconv.FormatInt(r.Avg, 10),
+			strconv.FormatInt(r.Max, 10),
+		}
+		for _, k := range cols {
+			var p Percentile
+			for _, v := range r.Percentiles {
+				if v.Percent == k {
+					p = v
+				}
+			}
+			row = append(row, strconv.FormatInt(p.Value, 10))
+			if uncorrected {
+				row = append(row, strconv.FormatInt(p.Uncorrected, 10))
+			}
+		}
+		row = append(row, errorsString(r.FailedErrors))
+		env := r.Env
+		if env == nil {
+			env = envs[r]
+		}
+		if env != nil {
+			row = append(row, env.Hostname, env.GoVersion, strconv.Itoa(env.GOMAXPROCS), env.Commit)
+		} else {
+			row = append(row, "", "", "", "")
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func WriteIntervalsCSV(w io.Writer, r *Result) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"begin", "used_ns", "success", "failed", "tps", "tp50_ns", "tp99_ns", "max_ns"})
+	if err != nil {
+		return err
+	}
+	for _, iv := range r.Intervals {
+		err = cw.Write([]string{
+			iv.Begin.Format(time.RFC3339Nano),
+			strconv.FormatInt(int64(iv.Used), 10),
+			strconv.FormatInt(iv.Success, 10),
+			strconv.FormatInt(iv.Failed, 10),
+			strconv.FormatInt(iv.TPS, 10),
+			strconv.FormatInt(iv.TP50, 10),
+			strconv.FormatInt(iv.TP99, 10),
+			strconv.FormatInt(iv.Max, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func errorsString(errs map[string]int) string {
+	keys := make([]string, 0, len(errs))
+	for k := range errs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = fmt.Sprintf("%v=%v", k, errs[k])
+	}
+	return strings.Join(s, ";")
+}
+
+func costHistogram(cost []int64) *Histogram {
+	h := NewHistogram(DefaultHistogramMax, DefaultHistogramDigits)
+	for _, v := range cost {
+		if v >= 0 {
+			h.Record(v)
+		}
+	}
+	return h
+}
@@ -0,0 +1,359 @@
+package perf
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultHistogramDigits = 3
+	DefaultHistogramMax    = int64(time.Hour)
+)
+
+// Histogram is a log-bucketed latency histogram in the style of HdrHistogram.
+// Values are kept to a fixed number of significant digits, so memory does not
+// grow with the number of samples. Record is safe for concurrent use.
+type Histogram struct {
+	digits                      int
+	highest                     int64
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int64
+	subBucketMask               int64
+	counts                      []int64
+
+	total int64
+	sum   int64
+	min   int64
+	max   int64
+}
+
+func NewHistogram(highest int64, digits int) *Histogram {
+	if digits < 1 {
+		digits = 1
+	}
+	if digits > 5 {
+		digits = 5
+	}
+	if highest < 2 {
+		highest = 2
+	}
+
+	largestSingleUnit := 2 * int64(math.Pow10(digits))
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(float64(largestSingleUnit))))
+	subBucketCount := int64(1) << subBucketCountMagnitude
+
+	buckets := 1
+	for smallestUntrackable := subBucketCount; smallestUntrackable <= highest; smallestUntrackable <<= 1 {
+		buckets++
+		if smallestUntrackable > math.MaxInt64/2 {
+			break
+		}
+	}
+
+	h := &Histogram{
+		digits:                      digits,
+		highest:                     highest,
+		subBucketHalfCountMagnitude: subBucketCountMagnitude - 1,
+		subBucketHalfCount:          subBucketCount / 2,
+		subBucketMask:               subBucketCount - 1,
+		min:                         math.MaxInt64,
+	}
+	h.counts = make([]int64, int64(buckets+1)*h.subBucketHalfCount)
+	return h
+}
+
+func (h *Histogram) Digits() int {
+	return h.digits
+}
+
+func (h *Histogram) Highest() int64 {
+	return h.highest
+}
+
+func (h *Histogram) Record(v int64) {
+	h.RecordN(v, 1)
+}
+
+func (h *Histogram) RecordN(v, n int64) {
+	if v < 0 {
+		v = 0
+	}
+	if v > h.highest {
+		v = h.highest
+	}
+	atomic.AddInt64(&h.counts[h.index(v)], n)
+	atomic.AddInt64(&h.total, n)
+	atomic.AddInt64(&h.sum, v*n)
+	for {
+		min := atomic.LoadInt64(&h.min)
+		if v >= min || atomic.CompareAndSwapInt64(&h.min, min, v) {
+			break
+		}
+	}
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			break
+		}
+	}
+}
+
+// Merge adds all samples of other into h. Histograms of a different layout
+// are merged at the precision of the coarser one.
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.TotalCount() == 0 {
+		return
+	}
+	if h.sameLayout(other) {
+		for i := range other.counts {
+			if n := atomic.LoadInt64(&other.counts[i]); n != 0 {
+				atomic.AddInt64(&h.counts[i], n)
+			}
+		}
+		atomic.AddInt64(&h.total, atomic.LoadInt64(&other.total))
+		atomic.AddInt64(&h.sum, atomic.LoadInt64(&other.sum))
+		h.RecordN(other.Min(), 0)
+		h.RecordN(other.Max(), 0)
+		return
+	}
+	for i := range other.counts {
+		if n := atomic.LoadInt64(&other.counts[i]); n != 0 {
+			h.RecordN(other.medianEquivalentValue(other.valueFromIndex(i)), n)
+		}
+	}
+}
+
+func (h *Histogram) Copy() *Histogram {
+	c := NewHistogram(h.highest, h.digits)
+	c.Merge(h)
+	return c
+}
+
+func (h *Histogram) Reset() {
+	for i := range h.counts {
+		atomic.StoreInt64(&h.counts[i], 0)
+	}
+	atomic.StoreInt64(&h.total, 0)
+	atomic.StoreInt64(&h.sum, 0)
+	atomic.StoreInt64(&h.min, math.MaxInt64)
+	atomic.StoreInt64(&h.max, 0)
+}
+
+func (h *Histogram) TotalCount() int64 {
+	return atomic.LoadInt64(&h.total)
+}
+
+func (h *Histogram) Min() int64 {
+	if h.TotalCount() == 0 {
+		return 0
+	}
+	return atomic.LoadInt64(&h.min)
+}
+
+func (h *Histogram) Max() int64 {
+	return atomic.LoadInt64(&h.max)
+}
+
+func (h *Histogram) Mean() int64 {
+	total := h.TotalCount()
+	if total == 0 {
+		return 0
+	}
+	return atomic.LoadInt64(&h.sum) / total
+}
+
+func (h *Histogram) StdDev() float64 {
+	total := h.TotalCount()
+	if total == 0 {
+		return 0
+	}
+	mean := float64(h.Mean())
+	var sum float64
+	for i := range h.counts {
+		if n := atomic.LoadInt64(&h.counts[i]); n != 0 {
+			d := float64(h.medianEquivalentValue(h.valueFromIndex(i))) - mean
+			sum += d * d * float64(n)
+		}
+	}
+	return math.Sqrt(sum / float64(total))
+}
+
+// ValueAtPercentile returns the value below which percentile (0-100) percent
+// of the samples fall, at the histogram's precision.
+func (h *Histogram) ValueAtPercentile(percentile float64) int64 {
+	total := h.TotalCount()
+	if total == 0 {
+		return 0
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+	target := int64(percentile/100*float64(total) + 0.5)
+	if target < 1 {
+		target = 1
+	}
+
+	var cnt int64
+	for i := range h.counts {
+		cnt += atomic.LoadInt64(&h.counts[i])
+		if cnt >= target {
+			v := h.highestEquivalentValue(h.valueFromIndex(i))
+			if max := h.Max(); v > max {
+				v = max
+			}
+			if min := h.Min(); v < min {
+				v = min
+			}
+			return v
+		}
+	}
+	return h.Max()
+}
+
+// TPN is ValueAtPercentile for a Calculator style percent, 999 is 99.9.
+func (h *Histogram) TPN(percent int) int64 {
+	return h.ValueAtPercentile(percentile(percent))
+}
+
+func (h *Histogram) sameLayout(other *Histogram) bool {
+	return h.digits == other.digits && h.highest == other.highest
+}
+
+func (h *Histogram) bucketIndex(v int64) int {
+	pow2ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	return pow2ceiling - int(h.subBucketHalfCountMagnitude+1)
+}
+
+func (h *Histogram) index(v int64) int {
+	bucketIdx := h.bucketIndex(v)
+	subBucketIdx := v >> uint(bucketIdx)
+	return (bucketIdx+1)<<h.subBucketHalfCountMagnitude + int(subBucketIdx-h.subBucketHalfCount)
+}
+
+func (h *Histogram) valueFromIndex(i int) int64 {
+	bucketIdx := (i >> h.subBucketHalfCountMagnitude) - 1
+	subBucketIdx := int64(i)&(h.subBucketHalfCount-1) + h.subBucketHalfCount
+	if bucketIdx < 0 {
+		subBucketIdx -= h.subBucketHalfCount
+		bucketIdx = 0
+	}
+	return subBucketIdx << uint(bucketIdx)
+}
+
+func (h *Histogram) equivalentRange(v int64) int64 {
+	bucketIdx := h.bucketIndex(v)
+	subBucketIdx := v >> uint(bucketIdx)
+	if subBucketIdx > h.subBucketMask {
+		bucketIdx++
+	}
+	return 1 << uint(bucketIdx)
+}
+
+func (h *Histogram) highestEquivalentValue(v int64) int64 {
+	return v + h.equivalentRange(v) - 1
+}
+
+func (h *Histogram) medianEquivalentValue(v int64) int64 {
+	return v + h.equivalentRange(v)/2
+}
+
+// Sub returns the samples recorded in h since prev, an earlier copy of h.
+// Min and Max of the result are bucket-precise rather than exact.
+func (h *Histogram) Sub(prev *Histogram) *Histogram {
+	d := NewHistogram(h.highest, h.digits)
+	if prev == nil || !h.sameLayout(prev) {
+		d.Merge(h)
+		return d
+	}
+
+	first, last := -1, -1
+	for i := range h.counts {
+		n := atomic.LoadInt64(&h.counts[i]) - atomic.LoadInt64(&prev.counts[i])
+		if n <= 0 {
+			continue
+		}
+		d.counts[i] = n
+		d.total += n
+		if first < 0 {
+			first = i
+		}
+		last = i
+	}
+	if d.total == 0 {
+		return d
+	}
+	d.sum = atomic.LoadInt64(&h.sum) - atomic.LoadInt64(&prev.sum)
+	d.min = h.valueFromIndex(first)
+	if min := h.Min(); d.min < min {
+		d.min = min
+	}
+	d.max = h.highestEquivalentValue(h.valueFromIndex(last))
+	if max := h.Max(); d.max > max {
+		d.max = max
+	}
+	return d
+}
+
+type histogramJSON struct {
+	Digits  int        `json:"digits"`
+	Highest int64      `json:"highest"`
+	Total   int64      `json:"total"`
+	Sum     int64      `json:"sum"`
+	Min     int64      `json:"min"`
+	Max     int64      `json:"max"`
+	Counts  [][2]int64 `json:"counts"`
+}
+
+// MarshalJSON encodes the non-empty buckets as [value, count] pairs.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	v := histogramJSON{
+		Digits:  h.digits,
+		Highest: h.highest,
+		Total:   h.TotalCount(),
+		Sum:     atomic.LoadInt64(&h.sum),
+		Min:     h.Min(),
+		Max:     h.Max(),
+		Counts:  [][2]int64{},
+	}
+	for i := range h.counts {
+		if n := atomic.LoadInt64(&h.counts[i]); n != 0 {
+			v.Counts = append(v.Counts, [2]int64{h.valueFromIndex(i), n})
+		}
+	}
+	return json.Marshal(v)
+}
+
+func (h *Histogram) UnmarshalJSON(b []byte) error {
+	var v histogramJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*h = *NewHistogram(v.Highest, v.Digits)
+	for _, c := range v.Counts {
+		if c[0] < 0 || c[0] > h.highest {
+			return fmt.Errorf("invalid histogram value: %v", c[0])
+		}
+		h.counts[h.index(c[0])] += c[1]
+	}
+	h.total = v.Total
+	h.sum = v.Sum
+	h.max = v.Max
+	if v.Total > 0 {
+		h.min = v.Min
+	}
+	return nil
+}
+
+// ForEach calls f for every non-empty bucket with a representative value of
+// the bucket and its count, in ascending order.
+func (h *Histogram) ForEach(f func(value, count int64)) {
+	for i := range h.counts {
+		if n := atomic.LoadInt64(&h.counts[i]); n != 0 {
+			f(h.medianEquivalentValue(h.valueFromIndex(i)), n)
+		}
+	}
+}
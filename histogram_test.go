@@ -0,0 +1,176 @@
+package perf
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// logUniform returns n log-uniform values between 1 and max.
+func logUniform(seed int64, n int, max float64) []int64 {
+	rnd := rand.New(rand.NewSource(seed))
+	values := make([]int64, n)
+	for i := range values {
+		values[i] = int64(math.Exp(rnd.Float64() * math.Log(max)))
+	}
+	return values
+}
+
+func histogramOf(highest int64, digits int, values []int64) *Histogram {
+	h := NewHistogram(highest, digits)
+	for _, v := range values {
+		h.Record(v)
+	}
+	return h
+}
+
+func sameHistogram(t *testing.T, name string, got, want *Histogram) {
+	t.Helper()
+	if got.TotalCount() != want.TotalCount() || got.Min() != want.Min() || got.Max() != want.Max() || got.Mean() != want.Mean() {
+		t.Fatalf("%v: total %v min %v max %v mean %v, want %v %v %v %v", name,
+			got.TotalCount(), got.Min(), got.Max(), got.Mean(),
+			want.TotalCount(), want.Min(), want.Max(), want.Mean())
+	}
+	if len(got.counts) != len(want.counts) {
+		t.Fatalf("%v: %v buckets, want %v", name, len(got.counts), len(want.counts))
+	}
+	for i := range got.counts {
+		if got.counts[i] != want.counts[i] {
+			t.Fatalf("%v: bucket %v has %v, want %v", name, i, got.counts[i], want.counts[i])
+		}
+	}
+}
+
+func TestHistogramPercentiles(t *testing.T) {
+	values := logUniform(1, 20000, 1e9)
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	for _, digits := range []int{1, 2, 3, 4} {
+		h := histogramOf(DefaultHistogramMax, digits, values)
+		if h.Min() != sorted[0] || h.Max() != sorted[len(sorted)-1] {
+			t.Fatalf("digits %v: min %v max %v, want %v %v", digits, h.Min(), h.Max(), sorted[0], sorted[len(sorted)-1])
+		}
+		for _, p := range []float64{0, 1, 10, 50, 90, 99, 99.9, 99.99, 100} {
+			rank := int(p/100*float64(len(sorted)) + 0.5)
+			if rank < 1 {
+				rank = 1
+			}
+			exact := sorted[rank-1]
+			got := h.ValueAtPercentile(p)
+			tolerance := float64(exact)*2/math.Pow10(digits) + 1
+			if math.Abs(float64(got-exact)) > tolerance {
+				t.Fatalf("digits %v: p%v is %v, exact %v", digits, p, got, exact)
+			}
+		}
+	}
+}
+
+func TestHistogramIndex(t *testing.T) {
+	for _, digits := range []int{1, 3, 5} {
+		h := NewHistogram(DefaultHistogramMax, digits)
+		for _, v := range append(logUniform(2, 1000, float64(DefaultHistogramMax)), 0, 1, 2, 1023, 1024, 1025, DefaultHistogramMax) {
+			i := h.index(v)
+			if i < 0 || i >= len(h.counts) {
+				t.Fatalf("digits %v: index %v of %v out of range", digits, i, v)
+			}
+			low := h.valueFromIndex(i)
+			if v < low || v > h.highestEquivalentValue(low) {
+				t.Fatalf("digits %v: %v in bucket [%v, %v]", digits, v, low, h.highestEquivalentValue(low))
+			}
+		}
+	}
+}
+
+func TestHistogramClamp(t *testing.T) {
+	h := NewHistogram(1000, 3)
+	h.Record(-5)
+	h.Record(5000)
+	if h.Min() != 0 || h.Max() != 1000 || h.TotalCount() != 2 {
+		t.Fatalf("min %v max %v total %v", h.Min(), h.Max(), h.TotalCount())
+	}
+	if v := h.ValueAtPercentile(100); v != 1000 {
+		t.Fatalf("p100 is %v, want 1000", v)
+	}
+
+	empty := NewHistogram(1000, 3)
+	if empty.Min() != 0 || empty.Max() != 0 || empty.ValueAtPercentile(50) != 0 {
+		t.Fatal("empty histogram is not all zero")
+	}
+}
+
+func TestHistogramMergeCopy(t *testing.T) {
+	a, b := logUniform(3, 5000, 1e8), logUniform(4, 5000, 1e6)
+	all := histogramOf(DefaultHistogramMax, 3, append(append([]int64{}, a...), b...))
+
+	h := histogramOf(DefaultHistogramMax, 3, a)
+	h.Merge(histogramOf(DefaultHistogramMax, 3, b))
+	sameHistogram(t, "merge", h, all)
+	sameHistogram(t, "copy", h.Copy(), all)
+
+	// a different layout is merged bucket by bucket at the coarser precision.
+	coarse := histogramOf(DefaultHistogramMax, 2, a)
+	coarse.Merge(histogramOf(DefaultHistogramMax, 4, b))
+	if coarse.TotalCount() != all.TotalCount() {
+		t.Fatalf("total %v, want %v", coarse.TotalCount(), all.TotalCount())
+	}
+	for _, p := range []float64{50, 99} {
+		want := float64(all.ValueAtPercentile(p))
+		if got := float64(coarse.ValueAtPercentile(p)); math.Abs(got-want) > want*0.03 {
+			t.Fatalf("p%v is %v, want about %v", p, got, want)
+		}
+	}
+}
+
+func TestHistogramSub(t *testing.T) {
+	a, b := logUniform(5, 3000, 1e7), logUniform(6, 3000, 1e9)
+	h := histogramOf(DefaultHistogramMax, 3, a)
+	prev := h.Copy()
+	for _, v := range b {
+		h.Record(v)
+	}
+	d := h.Sub(prev)
+	want := histogramOf(DefaultHistogramMax, 3, b)
+	if d.TotalCount() != want.TotalCount() || d.Mean() != want.Mean() {
+		t.Fatalf("total %v mean %v, want %v %v", d.TotalCount(), d.Mean(), want.TotalCount(), want.Mean())
+	}
+	for i := range d.counts {
+		if d.counts[i] != want.counts[i] {
+			t.Fatalf("bucket %v has %v, want %v", i, d.counts[i], want.counts[i])
+		}
+	}
+	for _, p := range []float64{1, 50, 99, 100} {
+		if got, exp := d.ValueAtPercentile(p), want.ValueAtPercentile(p); got != exp {
+			t.Fatalf("p%v is %v, want %v", p, got, exp)
+		}
+	}
+	if e := h.Sub(h.Copy()); e.TotalCount() != 0 || e.Max() != 0 {
+		t.Fatalf("empty sub has total %v max %v", e.TotalCount(), e.Max())
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	for _, digits := range []int{1, 3} {
+		h := histogramOf(DefaultHistogramMax, digits, logUniform(7, 2000, 1e9))
+		b, err := json.Marshal(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Histogram
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Digits() != digits || got.Highest() != DefaultHistogramMax {
+			t.Fatalf("digits %v highest %v", got.Digits(), got.Highest())
+		}
+		sameHistogram(t, "json", &got, h)
+	}
+
+	var h Histogram
+	if err := json.Unmarshal([]byte(`{"digits":3,"highest":100,"counts":[[1000,1]]}`), &h); err == nil {
+		t.Fatal("expected an error for a value above highest")
+	}
+}
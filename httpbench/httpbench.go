@@ -0,0 +1,279 @@
+package httpbench
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/lesismal/perf"
+)
+
+type Options struct {
+	Method string
+	URL    string
+	Header http.Header
+	// Body is a text/template executed for every request with a
+	// *BodyData, e.g. `{"id": {{.Iteration}}}`.
+	Body string
+	// StatusCodes lists the accepted response codes, any 2xx if empty.
+	StatusCodes []int
+	// Client defaults to a client keeping idle connections for all workers.
+	Client *http.Client
+	// HistogramDigits is the precision of the phase histograms,
+	// perf.DefaultHistogramDigits if zero.
+	HistogramDigits int
+}
+
+type BodyData struct {
+	Worker    int
+	Iteration int
+}
+
+type Phase struct {
+	Name string
+	Hist *perf.Histogram
+}
+
+// Executor is a perf.Executor sending one HTTP request per call. Besides
+// the round trip measured by the Calculator it records these phases:
+//   - DNS:       DNS lookup, only for new connections
+//   - Connect:   TCP connect, only for new connections
+//   - TLS:       TLS handshake, only for new connections
+//   - FirstByte: from the request being written to the first response byte
+//   - BodyRead:  from the first response byte to the end of the body
+type Executor struct {
+	opt       Options
+	client    *http.Client
+	body      []byte
+	tmpl      *template.Template
+	codes     map[int]bool
+	DNS       *perf.Histogram
+	Connect   *perf.Histogram
+	TLS       *perf.Histogram
+	FirstByte *perf.Histogram
+	BodyRead  *perf.Histogram
+}
+
+func New(opt Options) (*Executor, error) {
+	if opt.Method == "" {
+		opt.Method = http.MethodGet
+	}
+	if opt.URL == "" {
+		return nil, fmt.Errorf("httpbench: empty url")
+	}
+	if opt.HistogramDigits <= 0 {
+		opt.HistogramDigits = perf.DefaultHistogramDigits
+	}
+
+	e := &Executor{
+		opt:       opt,
+		client:    opt.Client,
+		DNS:       perf.NewHistogram(perf.DefaultHistogramMax, opt.HistogramDigits),
+		Connect:   perf.NewHistogram(perf.DefaultHistogramMax, opt.HistogramDigits),
+		TLS:       perf.NewHistogram(perf.DefaultHistogramMax, opt.HistogramDigits),
+		FirstByte: perf.NewHistogram(perf.DefaultHistogramMax, opt.HistogramDigits),
+		BodyRead:  perf.NewHistogram(perf.DefaultHistogramMax, opt.HistogramDigits),
+	}
+	if e.client == nil {
+		e.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConns:        0,
+				MaxIdleConnsPerHost: 1 << 16,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+	}
+	if strings.Contains(opt.Body, "{{") {
+		tmpl, err := template.New("body").Parse(opt.Body)
+		if err != nil {
+			return nil, err
+		}
+		e.tmpl = tmpl
+	} else {
+		e.body = []byte(opt.Body)
+	}
+	if len(opt.StatusCodes) > 0 {
+		e.codes = map[int]bool{}
+		for _, code := range opt.StatusCodes {
+			e.codes[code] = true
+		}
+	}
+	return e, nil
+}
+
+func (e *Executor) Setup(worker int) error {
+	return nil
+}
+
+func (e *Executor) Teardown(worker int) error {
+	if worker == 0 {
+		e.client.CloseIdleConnections()
+	}
+	return nil
+}
+
+func (e *Executor) Do(ctx context.Context, worker, iteration int) error {
+	body := e.body
+	if e.tmpl != nil {
+		buf := &bytes.Buffer{}
+		if err := e.tmpl.Execute(buf, &BodyData{Worker: worker, Iteration: iteration}); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = bytes.NewReader(body)
+	}
+
+	t := &trace{}
+	ctx = httptrace.WithClientTrace(ctx, t.clientTrace())
+	req, err := http.NewRequestWithContext(ctx, e.opt.Method, e.opt.URL, reader)
+	if err != nil {
+		return err
+	}
+	for k, v := range e.opt.Header {
+		req.Header[k] = v
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	done := time.Now()
+	if err != nil {
+		return err
+	}
+
+	t.record(e, done)
+
+	if e.codes != nil {
+		if !e.codes[resp.StatusCode] {
+			return fmt.Errorf("unexpected status: %v", resp.StatusCode)
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %v", resp.StatusCode)
+	}
+	return nil
+}
+
+func (e *Executor) Phases() []Phase {
+	return []Phase{
+		{"DNS", e.DNS},
+		{"CONNECT", e.Connect},
+		{"TLS", e.TLS},
+		{"FIRSTBYTE", e.FirstByte},
+		{"BODYREAD", e.BodyRead},
+	}
+}
+
+func (e *Executor) Table(percents []int) *perf.Table {
+	title := []string{"Phase", "Count", "Min", "Avg", "Max"}
+	for _, k := range percents {
+		title = append(title, fmt.Sprintf("TP%v", k))
+	}
+	t := perf.NewTable()
+	t.SetTitle(title)
+	for _, p := range e.Phases() {
+		row := []string{
+			p.Name,
+			fmt.Sprintf("%v", p.Hist.TotalCount()),
+			perf.I2TimeString(p.Hist.Min()),
+			perf.I2TimeString(p.Hist.Mean()),
+			perf.I2TimeString(p.Hist.Max()),
+		}
+		for _, k := range percents {
+			row = append(row, perf.I2TimeString(p.Hist.TPN(k)))
+		}
+		t.AddRow(row)
+	}
+	return t
+}
+
+// Benchmark runs e with c and returns e so the phases can be inspected.
+func Benchmark(ctx context.Context, c *perf.Calculator, opt perf.BenchmarkOptions, hopt Options) (*Executor, error) {
+	e, err := New(hopt)
+	if err != nil {
+		return nil, err
+	}
+	c.BenchmarkExecutor(ctx, opt, e)
+	return e, nil
+}
+
+type trace struct {
+	mux          sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wrote        time.Time
+	firstByte    time.Time
+}
+
+func (t *trace) set(p *time.Time) {
+	now := time.Now()
+	t.mux.Lock()
+	if p.IsZero() {
+		*p = now
+	}
+	t.mux.Unlock()
+}
+
+func (t *trace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { t.set(&t.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectStart: func(network, addr string) {
+			t.set(&t.connectStart)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				t.set(&t.connectDone)
+			}
+		},
+		TLSHandshakeStart: func() { t.set(&t.tlsStart) },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err == nil {
+				t.set(&t.tlsDone)
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.set(&t.wrote)
+		},
+		GotFirstResponseByte: func() { t.set(&t.firstByte) },
+	}
+}
+
+func (t *trace) record(e *Executor, done time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	recordPhase(e.DNS, t.dnsStart, t.dnsDone)
+	recordPhase(e.Connect, t.connectStart, t.connectDone)
+	recordPhase(e.TLS, t.tlsStart, t.tlsDone)
+	recordPhase(e.FirstByte, t.wrote, t.firstByte)
+	recordPhase(e.BodyRead, t.firstByte, done)
+}
+
+func recordPhase(h *perf.Histogram, begin, end time.Time) {
+	if begin.IsZero() || end.IsZero() || end.Before(begin) {
+		return
+	}
+	h.Record(end.Sub(begin).Nanoseconds())
+}
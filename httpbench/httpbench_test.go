@@ -0,0 +1,118 @@
+package httpbench
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lesismal/perf"
+)
+
+func TestBenchmarkBodyTemplate(t *testing.T) {
+	var (
+		mux    sync.Mutex
+		bodies = map[int]BodyData{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data BodyData
+		b, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("X-Test") != "perf" || json.Unmarshal(b, &data) != nil {
+			http.Error(w, string(b), http.StatusBadRequest)
+			return
+		}
+		mux.Lock()
+		bodies[data.Iteration] = data
+		mux.Unlock()
+		w.Write([]byte(strings.Repeat("x", 1024)))
+	}))
+	defer srv.Close()
+
+	c := perf.NewCalculator("http")
+	e, err := Benchmark(context.Background(), c, perf.BenchmarkOptions{Concurrent: 4, Times: 100}, Options{
+		Method: http.MethodPost,
+		URL:    srv.URL,
+		Header: http.Header{"X-Test": []string{"perf"}},
+		Body:   `{"Worker": {{.Worker}}, "Iteration": {{.Iteration}}}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Success != 100 || c.Failed != 0 {
+		t.Fatalf("success %v, failed %v %v", c.Success, c.Failed, c.FailedErrors)
+	}
+	if len(bodies) != 100 {
+		t.Fatalf("%v distinct iterations, want 100", len(bodies))
+	}
+	for i := 1; i <= 100; i++ {
+		data, ok := bodies[i]
+		if !ok || data.Worker < 0 || data.Worker >= 4 {
+			t.Fatalf("iteration %v: %+v %v", i, data, ok)
+		}
+	}
+
+	// connections are reused, so only the first call of a worker connects.
+	if n := e.Connect.TotalCount(); n < 1 || n > 4 {
+		t.Fatalf("%v connects, want 1 to 4", n)
+	}
+	if n := e.FirstByte.TotalCount(); n != 100 {
+		t.Fatalf("%v first bytes, want 100", n)
+	}
+	if n := e.BodyRead.TotalCount(); n != 100 {
+		t.Fatalf("%v body reads, want 100", n)
+	}
+	if n := e.TLS.TotalCount(); n != 0 {
+		t.Fatalf("%v TLS handshakes over plain http", n)
+	}
+}
+
+func TestBenchmarkStatusCodes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c := perf.NewCalculator("http")
+	if _, err := Benchmark(context.Background(), c, perf.BenchmarkOptions{Concurrent: 2, Times: 10}, Options{URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Success != 0 || c.Failed != 10 || c.FailedErrors["unexpected status: 404"] != 10 {
+		t.Fatalf("any 2xx: success %v, failed %v %v", c.Success, c.Failed, c.FailedErrors)
+	}
+
+	c = perf.NewCalculator("http")
+	e, err := Benchmark(context.Background(), c, perf.BenchmarkOptions{Concurrent: 2, Times: 10}, Options{URL: srv.URL, StatusCodes: []int{404}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Success != 10 || c.Failed != 0 {
+		t.Fatalf("404 accepted: success %v, failed %v %v", c.Success, c.Failed, c.FailedErrors)
+	}
+	// rejected responses are still timed.
+	if n := e.FirstByte.TotalCount(); n != 10 {
+		t.Fatalf("%v first bytes, want 10", n)
+	}
+}
+
+func TestBenchmarkTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	c := perf.NewCalculator("https")
+	e, err := Benchmark(context.Background(), c, perf.BenchmarkOptions{Concurrent: 2, Times: 20}, Options{URL: srv.URL, Client: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Success != 20 {
+		t.Fatalf("success %v, failed %v %v", c.Success, c.Failed, c.FailedErrors)
+	}
+	if e.TLS.TotalCount() < 1 || e.TLS.TotalCount() != e.Connect.TotalCount() {
+		t.Fatalf("%v TLS handshakes for %v connects", e.TLS.TotalCount(), e.Connect.TotalCount())
+	}
+}
@@ -0,0 +1,31 @@
+package netutil
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// WatchCancel makes a cancelled ctx expire conn's deadline, which unblocks a
+// pending read or write even when ctx has no deadline of its own. The
+// returned func stops watching and waits for the watcher to exit, so it
+// cannot touch conn once the call is over.
+func WatchCancel(ctx context.Context, conn net.Conn) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		<-exited
+	}
+}
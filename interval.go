@@ -0,0 +1,119 @@
+package perf
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Interval struct {
+	Begin   time.Time     `json:"begin"`
+	Used    time.Duration `json:"used"`
+	Success int64         `json:"success"`
+	Failed  int64         `json:"failed"`
+	TPS     int64         `json:"tps"`
+	TP50    int64         `json:"tp50"`
+	TP99    int64         `json:"tp99"`
+	Max     int64         `json:"max"`
+}
+
+// timeline cuts a run into fixed intervals. Workers record into cumulative
+// histograms; every tick the previous snapshot is subtracted, so recording
+// never has to synchronize with the ticker.
+type timeline struct {
+	interval time.Duration
+	hists    []*Histogram
+	success  int64
+	failed   int64
+
+	prev        *Histogram
+	prevSuccess int64
+	prevFailed  int64
+	prevTime    time.Time
+
+	intervals  []*Interval
+	onInterval func(iv *Interval, success, failed int64)
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newTimeline(interval time.Duration, workers int) *timeline {
+	n := runtime.GOMAXPROCS(0) * 2
+	if n > workers {
+		n = workers
+	}
+	return &timeline{
+		interval: interval,
+		hists:    newHistograms(n, 2),
+		done:     make(chan struct{}),
+	}
+}
+
+func (t *timeline) add(worker int, cost int64, err error) {
+	if err != nil {
+		atomic.AddInt64(&t.failed, 1)
+		return
+	}
+	atomic.AddInt64(&t.success, 1)
+	t.hists[worker%len(t.hists)].Record(cost)
+}
+
+func (t *timeline) start() {
+	t.prevTime = time.Now()
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.done:
+				return
+			case <-ticker.C:
+				t.tick(true)
+			}
+		}
+	}()
+}
+
+// stop flushes the last partial interval without calling onInterval, the
+// caller reports the end of the run itself.
+func (t *timeline) stop() {
+	close(t.done)
+	t.wg.Wait()
+	if atomic.LoadInt64(&t.success)+atomic.LoadInt64(&t.failed) > t.prevSuccess+t.prevFailed {
+		t.tick(false)
+	}
+}
+
+func (t *timeline) tick(notify bool) {
+	now := time.Now()
+	cur := mergeHistograms(t.hists)
+	success := atomic.LoadInt64(&t.success)
+	failed := atomic.LoadInt64(&t.failed)
+	diff := cur.Sub(t.prev)
+
+	iv := &Interval{
+		Begin:   t.prevTime,
+		Used:    now.Sub(t.prevTime),
+		Success: success - t.prevSuccess,
+		Failed:  failed - t.prevFailed,
+		TP50:    diff.ValueAtPercentile(50),
+		TP99:    diff.ValueAtPercentile(99),
+		Max:     diff.Max(),
+	}
+	if iv.Used > 0 {
+		iv.TPS = int64(float64(iv.Success) / iv.Used.Seconds())
+	}
+	t.intervals = append(t.intervals, iv)
+	if notify && t.onInterval != nil {
+		t.onInterval(iv, success, failed)
+	}
+
+	t.prev = cur
+	t.prevSuccess = success
+	t.prevFailed = failed
+	t.prevTime = now
+}
@@ -0,0 +1,271 @@
+package netbench
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lesismal/perf"
+	"github.com/lesismal/perf/internal/netutil"
+)
+
+const (
+	DefaultPayloadSize = 64
+	DefaultUDPTimeout  = time.Second
+)
+
+var ErrEchoMismatch = errors.New("echo mismatch")
+
+type EchoOptions struct {
+	// Network is "tcp", "tcp4", "tcp6", "udp", "udp4" or "udp6".
+	Network string
+	Addr    string
+	// PayloadSize is the size of every message, DefaultPayloadSize if zero.
+	PayloadSize int
+	// Pipeline is the number of messages in flight per call. Each call
+	// writes Pipeline messages and waits for all of their echoes, so the
+	// Calculator measures the batch while Echo.RTT has every message.
+	Pipeline    int
+	DialTimeout time.Duration
+	// Timeout bounds a call whose context has no deadline. It defaults to
+	// DefaultUDPTimeout for udp, so a lost datagram fails the call instead
+	// of blocking the worker; tcp waits forever by default.
+	Timeout         time.Duration
+	HistogramDigits int
+}
+
+// Echo is a perf.Executor holding one connection per worker to an echo
+// server. Every call sends opt.Pipeline messages, verifies the echoed bytes
+// and records each message's round trip in RTT. A connection that failed is
+// redialed by the worker's next call.
+type Echo struct {
+	opt   EchoOptions
+	udp   bool
+	conns sync.Map
+
+	RTT       *perf.Histogram
+	Messages  int64
+	BytesSent int64
+	BytesRecv int64
+}
+
+type echoConn struct {
+	conn     net.Conn
+	seq      uint64
+	payloads [][]byte
+	sent     []int64
+	buf      []byte
+}
+
+func NewEcho(opt EchoOptions) (*Echo, error) {
+	if opt.Network == "" {
+		opt.Network = "tcp"
+	}
+	if opt.Addr == "" {
+		return nil, fmt.Errorf("netbench: empty addr")
+	}
+	if opt.PayloadSize <= 0 {
+		opt.PayloadSize = DefaultPayloadSize
+	}
+	if opt.Pipeline <= 0 {
+		opt.Pipeline = 1
+	}
+	if opt.HistogramDigits <= 0 {
+		opt.HistogramDigits = perf.DefaultHistogramDigits
+	}
+
+	e := &Echo{
+		opt: opt,
+		udp: strings.HasPrefix(opt.Network, "udp"),
+		RTT: perf.NewHistogram(perf.DefaultHistogramMax, opt.HistogramDigits),
+	}
+	if e.udp && opt.Timeout <= 0 {
+		e.opt.Timeout = DefaultUDPTimeout
+	}
+	return e, nil
+}
+
+// BenchmarkEcho is NewEcho followed by c.BenchmarkExecutor. c times whole
+// calls, the returned Echo has the per-message RTT and the bytes moved.
+func BenchmarkEcho(ctx context.Context, c *perf.Calculator, opt perf.BenchmarkOptions, eopt EchoOptions) (*Echo, error) {
+	e, err := NewEcho(eopt)
+	if err != nil {
+		return nil, err
+	}
+	c.BenchmarkExecutor(ctx, opt, e)
+	return e, nil
+}
+
+func (e *Echo) Setup(worker int) error {
+	c := &echoConn{
+		payloads: make([][]byte, e.opt.Pipeline),
+		sent:     make([]int64, e.opt.Pipeline),
+		buf:      make([]byte, e.opt.PayloadSize+1),
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano() + int64(worker)))
+	for i := range c.payloads {
+		c.payloads[i] = make([]byte, e.opt.PayloadSize)
+		rnd.Read(c.payloads[i])
+	}
+	if err := e.dial(c); err != nil {
+		return err
+	}
+	e.conns.Store(worker, c)
+	return nil
+}
+
+func (e *Echo) Teardown(worker int) error {
+	v, ok := e.conns.LoadAndDelete(worker)
+	if !ok {
+		return nil
+	}
+	c := v.(*echoConn)
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (e *Echo) Do(ctx context.Context, worker, iteration int) error {
+	v, ok := e.conns.Load(worker)
+	if !ok {
+		return fmt.Errorf("netbench: worker %v not set up", worker)
+	}
+	c := v.(*echoConn)
+	if c.conn == nil {
+		if err := e.dial(c); err != nil {
+			return err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok && e.opt.Timeout > 0 {
+		deadline = time.Now().Add(e.opt.Timeout)
+	}
+	c.conn.SetDeadline(deadline)
+	defer netutil.WatchCancel(ctx, c.conn)()
+
+	var err error
+	if e.udp {
+		err = e.roundTripUDP(c)
+	} else {
+		err = e.roundTripTCP(c)
+	}
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return err
+}
+
+func (e *Echo) dial(c *echoConn) error {
+	d := net.Dialer{Timeout: e.opt.DialTimeout}
+	conn, err := d.Dial(e.opt.Network, e.opt.Addr)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return nil
+}
+
+// stamp writes the message sequence number into the head of the payload,
+// so stale UDP echoes of an earlier, failed call can be told apart.
+func (c *echoConn) stamp(i int) {
+	c.seq++
+	if len(c.payloads[i]) >= 8 {
+		binary.BigEndian.PutUint64(c.payloads[i], c.seq)
+	}
+}
+
+func (e *Echo) send(c *echoConn, i int) error {
+	atomic.StoreInt64(&c.sent[i], time.Now().UnixNano())
+	n, err := c.conn.Write(c.payloads[i])
+	atomic.AddInt64(&e.BytesSent, int64(n))
+	return err
+}
+
+func (e *Echo) received(c *echoConn, i int) {
+	e.RTT.Record(time.Now().UnixNano() - atomic.LoadInt64(&c.sent[i]))
+	atomic.AddInt64(&e.Messages, 1)
+	atomic.AddInt64(&e.BytesRecv, int64(len(c.payloads[i])))
+}
+
+func (e *Echo) roundTripTCP(c *echoConn) error {
+	for i := range c.payloads {
+		c.stamp(i)
+	}
+
+	// with more than one message in flight the writes run concurrently
+	// with the reads, otherwise a large pipeline could fill both socket
+	// buffers and deadlock against the echo server.
+	werr := make(chan error, 1)
+	if len(c.payloads) == 1 {
+		werr <- e.send(c, 0)
+	} else {
+		go func() {
+			for i := range c.payloads {
+				if err := e.send(c, i); err != nil {
+					werr <- err
+					return
+				}
+			}
+			werr <- nil
+		}()
+	}
+
+	// closing the conn on failure unblocks a pending writer.
+	buf := c.buf[:e.opt.PayloadSize]
+	for i := range c.payloads {
+		if _, err := io.ReadFull(c.conn, buf); err != nil {
+			c.conn.Close()
+			<-werr
+			return err
+		}
+		if !bytes.Equal(buf, c.payloads[i]) {
+			c.conn.Close()
+			<-werr
+			return ErrEchoMismatch
+		}
+		e.received(c, i)
+	}
+	return <-werr
+}
+
+func (e *Echo) roundTripUDP(c *echoConn) error {
+	first := c.seq + 1
+	for i := range c.payloads {
+		c.stamp(i)
+		if err := e.send(c, i); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < len(c.payloads); {
+		n, err := c.conn.Read(c.buf)
+		if err != nil {
+			return err
+		}
+		msg := c.buf[:n]
+		if n >= 8 && e.opt.PayloadSize >= 8 {
+			seq := binary.BigEndian.Uint64(msg)
+			if seq < first+uint64(i) {
+				continue
+			}
+		}
+		if !bytes.Equal(msg, c.payloads[i]) {
+			return ErrEchoMismatch
+		}
+		e.received(c, i)
+		i++
+	}
+	return nil
+}
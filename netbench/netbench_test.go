@@ -0,0 +1,176 @@
+package netbench
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lesismal/perf"
+)
+
+func TestEcho(t *testing.T) {
+	for _, network := range []string{"tcp", "udp"} {
+		s, err := NewEchoServer(network, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// payloads under 8 bytes carry no sequence number.
+		for _, size := range []int{4, 64, 1000} {
+			for _, pipeline := range []int{1, 16} {
+				c := perf.NewCalculator("echo")
+				e, err := BenchmarkEcho(context.Background(), c, perf.BenchmarkOptions{Concurrent: 4, Times: 200},
+					EchoOptions{Network: network, Addr: s.Addr(), PayloadSize: size, Pipeline: pipeline})
+				if err != nil {
+					t.Fatal(err)
+				}
+				msgs := int64(200 * pipeline)
+				if c.Success != 200 || c.Failed != 0 {
+					t.Fatalf("%v size %v pipeline %v: success %v, failed %v %v", network, size, pipeline, c.Success, c.Failed, c.FailedErrors)
+				}
+				if e.Messages != msgs || e.RTT.TotalCount() != msgs {
+					t.Fatalf("%v size %v pipeline %v: %v messages, %v RTTs, want %v", network, size, pipeline, e.Messages, e.RTT.TotalCount(), msgs)
+				}
+				if e.BytesSent != msgs*int64(size) || e.BytesRecv != msgs*int64(size) {
+					t.Fatalf("%v size %v pipeline %v: sent %v, received %v", network, size, pipeline, e.BytesSent, e.BytesRecv)
+				}
+			}
+		}
+		s.Close()
+	}
+}
+
+// udpServer answers every datagram with reply, on its own goroutine.
+func udpServer(t *testing.T, reply func(pc net.PacketConn, b []byte, addr net.Addr)) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			reply(pc, buf[:n], addr)
+		}
+	}()
+	return pc
+}
+
+func TestEchoUDPSkipsStaleEchoes(t *testing.T) {
+	// every datagram is echoed twice, the second copy is stale by the time
+	// the next message of the batch is expected.
+	pc := udpServer(t, func(pc net.PacketConn, b []byte, addr net.Addr) {
+		pc.WriteTo(b, addr)
+		pc.WriteTo(b, addr)
+	})
+	defer pc.Close()
+
+	c := perf.NewCalculator("echo")
+	e, err := BenchmarkEcho(context.Background(), c, perf.BenchmarkOptions{Concurrent: 2, Times: 50},
+		EchoOptions{Network: "udp", Addr: pc.LocalAddr().String(), Pipeline: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Success != 50 || c.Failed != 0 || e.Messages != 200 {
+		t.Fatalf("success %v, failed %v %v, %v messages", c.Success, c.Failed, c.FailedErrors, e.Messages)
+	}
+}
+
+func TestEchoMismatch(t *testing.T) {
+	pc := udpServer(t, func(pc net.PacketConn, b []byte, addr net.Addr) {
+		b[len(b)-1]++
+		pc.WriteTo(b, addr)
+	})
+	defer pc.Close()
+
+	c := perf.NewCalculator("echo")
+	if _, err := BenchmarkEcho(context.Background(), c, perf.BenchmarkOptions{Concurrent: 1, Times: 10},
+		EchoOptions{Network: "udp", Addr: pc.LocalAddr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	if c.Failed != 10 || c.FailedErrors[ErrEchoMismatch.Error()] != 10 {
+		t.Fatalf("failed %v %v, want 10 mismatches", c.Failed, c.FailedErrors)
+	}
+}
+
+func TestEchoCancel(t *testing.T) {
+	// accept and never reply, only a cancelled ctx can end the calls.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+
+	for _, pipeline := range []int{1, 16} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		done := make(chan error, 1)
+		go func() {
+			_, err := BenchmarkEcho(ctx, perf.NewCalculator("echo"), perf.BenchmarkOptions{Concurrent: 2, Duration: time.Minute},
+				EchoOptions{Addr: ln.Addr().String(), Pipeline: pipeline, PayloadSize: 1 << 20})
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("pipeline %v: BenchmarkEcho did not return after ctx was cancelled", pipeline)
+		}
+	}
+}
+
+func TestEchoServerClose(t *testing.T) {
+	s, err := NewEchoServer("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on a connected client")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(buf); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("got %v, want the conn closed by the server", err)
+	}
+}
@@ -0,0 +1,108 @@
+package netbench
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// EchoServer echoes tcp streams with io.Copy and udp datagrams one at a
+// time. Run against it, Echo shows the floor set by the client and the
+// loopback stack alone.
+type EchoServer struct {
+	ln    net.Listener
+	pc    net.PacketConn
+	mux   sync.Mutex
+	conns map[net.Conn]struct{}
+	// closed turns away conns accepted while Close runs.
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewEchoServer(network, addr string) (*EchoServer, error) {
+	s := &EchoServer{conns: map[net.Conn]struct{}{}}
+	if strings.HasPrefix(network, "udp") {
+		pc, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s.pc = pc
+		s.wg.Add(1)
+		go s.servePacket()
+		return s, nil
+	}
+
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s.ln = ln
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *EchoServer) Addr() string {
+	if s.pc != nil {
+		return s.pc.LocalAddr().String()
+	}
+	return s.ln.Addr().String()
+}
+
+func (s *EchoServer) Close() error {
+	var err error
+	if s.pc != nil {
+		err = s.pc.Close()
+	} else {
+		err = s.ln.Close()
+		s.mux.Lock()
+		s.closed = true
+		for c := range s.conns {
+			c.Close()
+		}
+		s.mux.Unlock()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *EchoServer) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mux.Lock()
+		if s.closed {
+			s.mux.Unlock()
+			c.Close()
+			continue
+		}
+		s.conns[c] = struct{}{}
+		s.mux.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			io.Copy(c, c)
+			c.Close()
+			s.mux.Lock()
+			delete(s.conns, c)
+			s.mux.Unlock()
+		}()
+	}
+}
+
+func (s *EchoServer) servePacket() {
+	defer s.wg.Done()
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.pc.WriteTo(buf[:n], addr)
+	}
+}
@@ -0,0 +1,86 @@
+package perf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+type Progress struct {
+	Name    string
+	Elapsed time.Duration
+	Success int64
+	Failed  int64
+	// TPS and TP99 cover the last interval only.
+	TPS  int64
+	TP99 int64
+	// ETA is negative when the end of the run can't be estimated.
+	ETA  time.Duration
+	Done bool
+}
+
+func (p *Progress) String() string {
+	eta := "-"
+	if p.ETA >= 0 {
+		eta = p.ETA.Round(time.Millisecond).String()
+	}
+	return fmt.Sprintf("%v: %v elapsed, %v success, %v failed, %v tps, tp99 %v, eta %v",
+		p.Name,
+		p.Elapsed.Round(time.Millisecond),
+		p.Success,
+		p.Failed,
+		p.TPS,
+		I2TimeString(p.TP99),
+		eta)
+}
+
+// NewProgressPrinter returns a BenchmarkOptions.Progress hook writing to w.
+// On a terminal the line is rewritten in place, otherwise one line is
+// written per update, which reads better in CI logs.
+func NewProgressPrinter(w io.Writer) func(p *Progress) {
+	inPlace := false
+	if f, ok := w.(*os.File); ok {
+		if stat, err := f.Stat(); err == nil {
+			inPlace = stat.Mode()&os.ModeCharDevice != 0
+		}
+	}
+	return func(p *Progress) {
+		if !inPlace {
+			fmt.Fprintln(w, p.String())
+			return
+		}
+		fmt.Fprintf(w, "\r\033[K%v", p.String())
+		if p.Done {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func (opt BenchmarkOptions) expectedDuration() time.Duration {
+	var total time.Duration
+	for _, st := range opt.Stages {
+		total += st.Duration
+	}
+	if opt.Duration > 0 && (total == 0 || opt.Duration < total) {
+		total = opt.Duration
+	}
+	return total
+}
+
+func (opt BenchmarkOptions) eta(elapsed time.Duration, done int64) time.Duration {
+	eta := time.Duration(-1)
+	if d := opt.expectedDuration(); d > 0 {
+		eta = d - elapsed
+	}
+	if opt.Times > 0 && done > 0 {
+		left := time.Duration(float64(elapsed) / float64(done) * float64(int64(opt.Times)-done))
+		if eta < 0 || left < eta {
+			eta = left
+		}
+	}
+	if eta < -1 {
+		eta = 0
+	}
+	return eta
+}
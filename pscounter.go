@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"math"
 	"os"
 	"os/exec"
 	"regexp"
@@ -14,16 +13,30 @@ import (
 	"sync"
 	"time"
 
+	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/net"
 	"github.com/shirou/gopsutil/process"
 )
 
+// Every Ret slice has a Time slice of the same length holding when each
+// sample was collected. A CPU sample covers the interval before its time,
+// RetCPUTimes holds the cumulative CPU times it is computed from.
 type psResult struct {
-	RetCPU       []float64                        `json:"cpu"`
-	RetMEM       []*process.MemoryInfoStat        `json:"mem"`
-	RetIO        []*process.IOCountersStat        `json:"io"`
-	RetNET       map[string][]*net.IOCountersStat `json:"net"`
-	RetGoroutine []int                            `json:"go"`
+	RetCPU        []float64                        `json:"cpu"`
+	RetCPUTimes   []*cpu.TimesStat                 `json:"cpu_times"`
+	RetMEM        []*process.MemoryInfoStat        `json:"mem"`
+	RetIO         []*process.IOCountersStat        `json:"io"`
+	RetNET        map[string][]*net.IOCountersStat `json:"net"`
+	RetGoroutine  []int                            `json:"go"`
+	RetRuntime    []*RuntimeStat                   `json:"runtime"`
+	TimeCPU       []time.Time                      `json:"cpu_time"`
+	TimeCPUTimes  []time.Time                      `json:"cpu_times_time"`
+	TimeMEM       []time.Time                      `json:"mem_time"`
+	TimeIO        []time.Time                      `json:"io_time"`
+	TimeNET       map[string][]time.Time           `json:"net_time"`
+	TimeGoroutine []time.Time                      `json:"go_time"`
+	TimeRuntime   []time.Time                      `json:"runtime_time"`
+	Env           *Environment                     `json:"env,omitempty"`
 }
 
 type PSCountOptions struct {
@@ -33,6 +46,20 @@ type PSCountOptions struct {
 	CountNET       bool
 	CountGoroutine bool
 	Interval       time.Duration
+
+	// CountNETPerNIC keeps RetNET per network interface instead of a
+	// single "all" entry.
+	CountNETPerNIC bool
+
+	// CountGoroutine and CountRuntime sample the Go runtime of the current
+	// process, whatever pid the PSCounter was created for.
+	CountRuntime bool
+
+	// RuntimeURLs makes CountRuntime poll another Go process instead, e.g.
+	// "http://host:port/debug/vars" for heap and GC from expvar memstats
+	// and "http://host:port/debug/pprof/goroutine?debug=1" for goroutines.
+	// A failed poll skips the sample.
+	RuntimeURLs []string
 }
 
 type PSCounter struct {
@@ -40,6 +67,7 @@ type PSCounter struct {
 	psResult
 	proc   *process.Process
 	cancel func()
+	mux    sync.Mutex
 }
 
 func (p *PSCounter) Start(opt PSCountOptions) {
@@ -50,6 +78,17 @@ func (p *PSCounter) Start(opt PSCountOptions) {
 	p.RetMEM = make([]*process.MemoryInfoStat, 0)
 	p.RetIO = make([]*process.IOCountersStat, 0)
 	p.RetNET = make(map[string][]*net.IOCountersStat)
+	p.RetGoroutine = nil
+	p.TimeCPU = nil
+	p.RetCPUTimes = nil
+	p.TimeCPUTimes = nil
+	p.TimeMEM = nil
+	p.TimeIO = nil
+	p.TimeNET = make(map[string][]time.Time)
+	p.TimeGoroutine = nil
+	p.RetRuntime = nil
+	p.TimeRuntime = nil
+	p.Env = CaptureEnvironment()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	p.cancel = cancel
@@ -58,19 +97,45 @@ func (p *PSCounter) Start(opt PSCountOptions) {
 		opt.Interval = time.Second
 	}
 
+	// CPU times, MEM and the cumulative counters are also sampled right
+	// away and once more on Stop, so they cover exactly the counted window.
 	if opt.CountCPU {
 		p.Add(1)
+		var (
+			last     *cpu.TimesStat
+			lastTime time.Time
+		)
+		countCPU := func(stop bool) {
+			stat, err := p.proc.Times()
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			p.mux.Lock()
+			p.RetCPUTimes = append(p.RetCPUTimes, stat)
+			p.TimeCPUTimes = append(p.TimeCPUTimes, now)
+			// the last partial interval is too short to be a usage sample
+			// of its own unless it is the only one.
+			d := now.Sub(lastTime)
+			if last != nil && d > 0 && (!stop || d >= opt.Interval/2 || len(p.RetCPU) == 0) {
+				p.RetCPU = append(p.RetCPU, (stat.Total()-last.Total())/d.Seconds()*100)
+				p.TimeCPU = append(p.TimeCPU, now)
+			}
+			p.mux.Unlock()
+			last, lastTime = stat, now
+		}
+		countCPU(false)
 		go func() {
 			defer p.Done()
+			ticker := time.NewTicker(opt.Interval)
+			defer ticker.Stop()
 			for {
 				select {
 				case <-ctx.Done():
+					countCPU(true)
 					return
-				default:
-				}
-				percent, err := p.proc.Percent(opt.Interval)
-				if err == nil {
-					p.RetCPU = append(p.RetCPU, percent)
+				case <-ticker.C:
+					countCPU(false)
 				}
 			}
 		}()
@@ -78,18 +143,27 @@ func (p *PSCounter) Start(opt PSCountOptions) {
 
 	if opt.CountMEM {
 		p.Add(1)
+		countMEM := func() {
+			stat, err := p.proc.MemoryInfo()
+			if err == nil {
+				p.mux.Lock()
+				p.RetMEM = append(p.RetMEM, stat)
+				p.TimeMEM = append(p.TimeMEM, time.Now())
+				p.mux.Unlock()
+			}
+		}
+		countMEM()
 		go func() {
 			defer p.Done()
 			ticker := time.NewTicker(opt.Interval)
+			defer ticker.Stop()
 			for {
 				select {
 				case <-ctx.Done():
+					countMEM()
 					return
 				case <-ticker.C:
-					stat, err := p.proc.MemoryInfo()
-					if err == nil {
-						p.RetMEM = append(p.RetMEM, stat)
-					}
+					countMEM()
 				}
 			}
 		}()
@@ -97,18 +171,27 @@ func (p *PSCounter) Start(opt PSCountOptions) {
 
 	if opt.CountIO {
 		p.Add(1)
+		countIO := func() {
+			stat, err := p.proc.IOCounters()
+			if err == nil {
+				p.mux.Lock()
+				p.RetIO = append(p.RetIO, stat)
+				p.TimeIO = append(p.TimeIO, time.Now())
+				p.mux.Unlock()
+			}
+		}
+		countIO()
 		go func() {
 			defer p.Done()
 			ticker := time.NewTicker(opt.Interval)
+			defer ticker.Stop()
 			for {
 				select {
 				case <-ctx.Done():
+					countIO()
 					return
 				case <-ticker.C:
-					stat, err := p.proc.IOCounters()
-					if err == nil {
-						p.RetIO = append(p.RetIO, stat)
-					}
+					countIO()
 				}
 			}
 		}()
@@ -116,23 +199,34 @@ func (p *PSCounter) Start(opt PSCountOptions) {
 
 	if opt.CountNET {
 		p.Add(1)
+		countNET := func() {
+			stats, err := p.proc.NetIOCounters(opt.CountNETPerNIC)
+			if err == nil {
+				now := time.Now()
+				p.mux.Lock()
+				for i := range stats {
+					stat := stats[i]
+					if p.RetNET[stat.Name] == nil {
+						p.RetNET[stat.Name] = make([]*net.IOCountersStat, 0)
+					}
+					p.RetNET[stat.Name] = append(p.RetNET[stat.Name], &stat)
+					p.TimeNET[stat.Name] = append(p.TimeNET[stat.Name], now)
+				}
+				p.mux.Unlock()
+			}
+		}
+		countNET()
 		go func() {
 			defer p.Done()
 			ticker := time.NewTicker(opt.Interval)
+			defer ticker.Stop()
 			for {
 				select {
 				case <-ctx.Done():
+					countNET()
 					return
 				case <-ticker.C:
-					stats, err := p.proc.NetIOCounters(false)
-					if err == nil {
-						for _, stat := range stats {
-							if p.RetNET[stat.Name] == nil {
-								p.RetNET[stat.Name] = make([]*net.IOCountersStat, 0)
-							}
-							p.RetNET[stat.Name] = append(p.RetNET[stat.Name], &stat)
-						}
-					}
+					countNET()
 				}
 			}
 		}()
@@ -142,13 +236,57 @@ func (p *PSCounter) Start(opt PSCountOptions) {
 		p.Add(1)
 		go func() {
 			defer p.Done()
+			ticker := time.NewTicker(opt.Interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					p.mux.Lock()
+					p.RetGoroutine = append(p.RetGoroutine, runtime.NumGoroutine())
+					p.TimeGoroutine = append(p.TimeGoroutine, time.Now())
+					p.mux.Unlock()
+				}
+			}
+		}()
+	}
+
+	if opt.CountRuntime {
+		p.Add(1)
+		var sample func() (*RuntimeStat, error)
+		if len(opt.RuntimeURLs) > 0 {
+			remote := newRemoteSampler(opt.RuntimeURLs, opt.Interval)
+			sample = func() (*RuntimeStat, error) {
+				return remote.sample(ctx)
+			}
+		} else {
+			local := newRuntimeSampler()
+			sample = func() (*RuntimeStat, error) {
+				return local.sample(), nil
+			}
+		}
+		go func() {
+			defer p.Done()
+			if len(opt.RuntimeURLs) > 0 {
+				// the first poll is the GC pause baseline.
+				sample()
+			}
+			ticker := time.NewTicker(opt.Interval)
+			defer ticker.Stop()
 			for {
 				select {
 				case <-ctx.Done():
 					return
-				default:
+				case <-ticker.C:
+					stat, err := sample()
+					if err == nil {
+						p.mux.Lock()
+						p.RetRuntime = append(p.RetRuntime, stat)
+						p.TimeRuntime = append(p.TimeRuntime, time.Now())
+						p.mux.Unlock()
+					}
 				}
-				p.RetGoroutine = append(p.RetGoroutine, runtime.NumCPU())
 			}
 		}()
 	}
@@ -171,357 +309,236 @@ func (p *PSCounter) Stop() {
 	// p.RetNET = make(map[string][]*net.IOCountersStat)
 }
 
-func (p *PSCounter) CPUMin() float64 {
-	var ret float64
-	if len(p.RetCPU) == 1 {
-		return p.RetCPU[0]
-	}
-	if len(p.RetCPU) > 1 {
-		ret = math.MaxFloat64
-		for i, v := range p.RetCPU {
-			if i > 0 && v < ret {
-				ret = v
-			}
+// Window returns a stopped PSCounter holding copies of the samples collected
+// between begin and end inclusive, so all of its statistics describe that
+// part of the run only, e.g. one stage of a benchmark.
+func (p *PSCounter) Window(begin, end time.Time) *PSCounter {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	w := &PSCounter{proc: p.proc}
+	w.Env = p.Env
+	w.RetCPU, w.TimeCPU = samplesBetween(p.RetCPU, p.TimeCPU, begin, end)
+	w.RetCPUTimes, w.TimeCPUTimes = samplesBetween(p.RetCPUTimes, p.TimeCPUTimes, begin, end)
+	w.RetMEM, w.TimeMEM = samplesBetween(p.RetMEM, p.TimeMEM, begin, end)
+	w.RetIO, w.TimeIO = samplesBetween(p.RetIO, p.TimeIO, begin, end)
+	w.RetGoroutine, w.TimeGoroutine = samplesBetween(p.RetGoroutine, p.TimeGoroutine, begin, end)
+	w.RetRuntime, w.TimeRuntime = samplesBetween(p.RetRuntime, p.TimeRuntime, begin, end)
+	w.RetNET = map[string][]*net.IOCountersStat{}
+	w.TimeNET = map[string][]time.Time{}
+	for name, stats := range p.RetNET {
+		w.RetNET[name], w.TimeNET[name] = samplesBetween(stats, p.TimeNET[name], begin, end)
+	}
+	return w
+}
+
+func samplesBetween[T any](values []T, times []time.Time, begin, end time.Time) ([]T, []time.Time) {
+	var (
+		wv = []T{}
+		wt = []time.Time{}
+	)
+	for i, t := range times {
+		if i < len(values) && !t.Before(begin) && !t.After(end) {
+			wv = append(wv, values[i])
+			wt = append(wt, t)
 		}
 	}
-	return ret
+	return wv, wt
 }
 
-func (p *PSCounter) CPUMax() float64 {
-	var ret float64 = 0.0
-	for _, v := range p.RetCPU {
-		if v > ret {
-			ret = v
-		}
-	}
-	return ret
+func (p *PSCounter) CPU() *Series[float64] {
+	return seriesOf(p.RetCPU, p.TimeCPU, func(v float64) float64 { return v })
 }
 
-func (p *PSCounter) CPUAvg() float64 {
-	if len(p.RetCPU) == 0 {
-		return 0.0
-	}
-	if len(p.RetCPU) == 1 {
-		return p.RetCPU[0]
-	}
-	var ret float64
-	for i, v := range p.RetCPU {
-		if i > 0 {
-			ret += v
-		}
-	}
-	return ret / float64(len(p.RetCPU)-1)
+// CPUTime is the cumulative CPU time of the process in seconds.
+func (p *PSCounter) CPUTime() *Series[float64] {
+	return seriesOf(p.RetCPUTimes, p.TimeCPUTimes, func(v *cpu.TimesStat) float64 { return v.Total() })
 }
 
-func (p *PSCounter) CPUAvgTrim(head, tail int) float64 {
-	if len(p.RetCPU) == 0 {
-		return 0.0
-	}
+func (p *PSCounter) MEMRSS() *Series[uint64] {
+	return seriesOf(p.RetMEM, p.TimeMEM, func(v *process.MemoryInfoStat) uint64 { return v.RSS })
+}
 
-	for head+tail > len(p.RetCPU) {
-		if head > 0 {
-			head--
-		}
-		if tail > 0 {
-			tail--
-		}
-	}
+func (p *PSCounter) MEMVMS() *Series[uint64] {
+	return seriesOf(p.RetMEM, p.TimeMEM, func(v *process.MemoryInfoStat) uint64 { return v.VMS })
+}
 
-	var n int
-	var ret float64
-	for i, v := range p.RetCPU {
-		if head < len(p.RetCPU) {
-			if i < head {
-				continue
-			}
-		}
-		if tail < len(p.RetCPU) {
-			if i < len(p.RetCPU)-tail {
-				continue
-			}
-		}
-		n++
-		ret += v
-	}
-	if n == 0 {
-		return 0.0
-	}
-	return ret / float64(n)
+// The IO and NET series are cumulative counters, see Series.Rate.
+
+func (p *PSCounter) IOReadCount() *Series[uint64] {
+	return seriesOf(p.RetIO, p.TimeIO, func(v *process.IOCountersStat) uint64 { return v.ReadCount })
 }
 
-func (p *PSCounter) MEMRSSMin() uint64 {
-	var ret uint64
-	sort.Slice(p.RetMEM, func(i, j int) bool {
-		return p.RetMEM[i].RSS < p.RetMEM[j].RSS
-	})
+func (p *PSCounter) IOWriteCount() *Series[uint64] {
+	return seriesOf(p.RetIO, p.TimeIO, func(v *process.IOCountersStat) uint64 { return v.WriteCount })
+}
 
-	if len(p.RetMEM) > 0 {
-		ret = p.RetMEM[0].RSS
-	}
-	if len(p.RetMEM) > 1 {
-		ret = p.RetMEM[1].RSS
+func (p *PSCounter) IOReadBytes() *Series[uint64] {
+	return seriesOf(p.RetIO, p.TimeIO, func(v *process.IOCountersStat) uint64 { return v.ReadBytes })
+}
+
+func (p *PSCounter) IOWriteBytes() *Series[uint64] {
+	return seriesOf(p.RetIO, p.TimeIO, func(v *process.IOCountersStat) uint64 { return v.WriteBytes })
+}
+
+// NETInterfaces lists the keys of RetNET, "all" unless CountNETPerNIC.
+func (p *PSCounter) NETInterfaces() []string {
+	names := make([]string, 0, len(p.RetNET))
+	for name := range p.RetNET {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *PSCounter) NETBytesSent(name string) *Series[uint64] {
+	return seriesOf(p.RetNET[name], p.TimeNET[name], func(v *net.IOCountersStat) uint64 { return v.BytesSent })
+}
 
-	return ret
+func (p *PSCounter) NETBytesRecv(name string) *Series[uint64] {
+	return seriesOf(p.RetNET[name], p.TimeNET[name], func(v *net.IOCountersStat) uint64 { return v.BytesRecv })
 }
 
+func (p *PSCounter) NETPacketsSent(name string) *Series[uint64] {
+	return seriesOf(p.RetNET[name], p.TimeNET[name], func(v *net.IOCountersStat) uint64 { return v.PacketsSent })
+}
+
+func (p *PSCounter) NETPacketsRecv(name string) *Series[uint64] {
+	return seriesOf(p.RetNET[name], p.TimeNET[name], func(v *net.IOCountersStat) uint64 { return v.PacketsRecv })
+}
+
+func (p *PSCounter) NumGoroutine() *Series[int] {
+	return seriesOf(p.RetGoroutine, p.TimeGoroutine, func(v int) int { return v })
+}
+
+// Deprecated: use p.CPU().Min().
+func (p *PSCounter) CPUMin() float64 {
+	return p.CPU().Min()
+}
+
+// Deprecated: use p.CPU().Max().
+func (p *PSCounter) CPUMax() float64 {
+	return p.CPU().Max()
+}
+
+// Deprecated: use p.CPU().Avg().
+func (p *PSCounter) CPUAvg() float64 {
+	return p.CPU().Avg()
+}
+
+// Deprecated: use p.CPU().Trim(head, tail).Avg().
+func (p *PSCounter) CPUAvgTrim(head, tail int) float64 {
+	return p.CPU().Trim(head, tail).Avg()
+}
+
+// Deprecated: use p.MEMRSS().Min().
+func (p *PSCounter) MEMRSSMin() uint64 {
+	return p.MEMRSS().Min()
+}
+
+// Deprecated: use p.MEMRSS().Max().
 func (p *PSCounter) MEMRSSMax() uint64 {
-	var ret uint64 = 0
-	for _, v := range p.RetMEM {
-		if v.RSS > ret {
-			ret = v.RSS
-		}
-	}
-	return ret
+	return p.MEMRSS().Max()
 }
 
+// Deprecated: use p.MEMRSS().Avg().
 func (p *PSCounter) MEMRSSAvg() uint64 {
-	if len(p.RetMEM) == 0 {
-		return 0
-	}
-	if len(p.RetMEM) == 1 {
-		return p.RetMEM[0].RSS
-	}
-	var ret uint64
-	for i, v := range p.RetMEM {
-		if i > 0 {
-			ret += v.RSS
-		}
-	}
-	return ret / uint64(len(p.RetMEM)-1)
+	return uint64(p.MEMRSS().Avg())
 }
 
+// Deprecated: use p.MEMRSS().Trim(head, tail).Avg().
 func (p *PSCounter) MEMRSSAvgTrim(head, tail int) uint64 {
-	if len(p.RetMEM) == 0 {
-		return 0
-	}
-
-	for head+tail > len(p.RetCPU) {
-		if head > 0 {
-			head--
-		}
-		if tail > 0 {
-			tail--
-		}
-	}
-
-	var n int
-	var ret uint64
-	for i, v := range p.RetMEM {
-		if head < len(p.RetCPU) {
-			if i < head {
-				continue
-			}
-		}
-		if tail < len(p.RetCPU) {
-			if i < len(p.RetCPU)-tail {
-				continue
-			}
-		}
-		n++
-		ret += v.RSS
-	}
-	if n == 0 {
-		return 0
-	}
-	return ret / uint64(len(p.RetMEM))
+	return uint64(p.MEMRSS().Trim(head, tail).Avg())
 }
 
+// Deprecated: use p.MEMVMS().Min().
 func (p *PSCounter) MEMVMSMin() uint64 {
-	var ret uint64 = math.MaxUint64
-	for _, v := range p.RetMEM {
-		if v.VMS < ret {
-			ret = v.VMS
-		}
-	}
-	return ret
+	return p.MEMVMS().Min()
 }
 
+// Deprecated: use p.MEMVMS().Max().
 func (p *PSCounter) MEMVMSMax() uint64 {
-	var ret uint64 = 0
-	for _, v := range p.RetMEM {
-		if v.VMS > ret {
-			ret = v.VMS
-		}
-	}
-	return ret
+	return p.MEMVMS().Max()
 }
 
+// Deprecated: use p.MEMVMS().Avg().
 func (p *PSCounter) MEMVMSAvg() uint64 {
-	if len(p.RetMEM) == 0 {
-		return 0
-	}
-	var ret uint64
-	for _, v := range p.RetMEM {
-		ret += v.VMS
-	}
-	return ret / uint64(len(p.RetMEM))
+	return uint64(p.MEMVMS().Avg())
 }
 
+// Deprecated: use p.IOReadCount().Min().
 func (p *PSCounter) IOReadCountMin() uint64 {
-	var ret uint64 = math.MaxUint64
-	for _, v := range p.RetIO {
-		if v.ReadCount < ret {
-			ret = v.ReadCount
-		}
-	}
-	return ret
+	return p.IOReadCount().Min()
 }
 
+// Deprecated: use p.IOReadCount().Max().
 func (p *PSCounter) IOReadCountMax() uint64 {
-	var ret uint64 = 0
-	for _, v := range p.RetIO {
-		if v.ReadCount > ret {
-			ret = v.ReadCount
-		}
-	}
-	return ret
+	return p.IOReadCount().Max()
 }
 
+// Deprecated: use p.IOReadCount().Avg().
 func (p *PSCounter) IOReadCountAvg() uint64 {
-	if len(p.RetIO) == 0 {
-		return 0
-	}
-	var ret uint64
-	for _, v := range p.RetIO {
-		ret += v.ReadCount
-	}
-	return ret / uint64(len(p.RetIO))
+	return uint64(p.IOReadCount().Avg())
 }
 
+// Deprecated: use p.IOReadBytes().Min().
 func (p *PSCounter) IOReadBytesMin() uint64 {
-	var ret uint64 = math.MaxUint64
-	for _, v := range p.RetIO {
-		if v.ReadBytes < ret {
-			ret = v.ReadBytes
-		}
-	}
-	return ret
+	return p.IOReadBytes().Min()
 }
 
+// Deprecated: use p.IOReadBytes().Max().
 func (p *PSCounter) IOReadBytesMax() uint64 {
-	var ret uint64 = 0
-	for _, v := range p.RetIO {
-		if v.ReadBytes > ret {
-			ret = v.ReadBytes
-		}
-	}
-	return ret
+	return p.IOReadBytes().Max()
 }
 
+// Deprecated: use p.IOReadBytes().Avg().
 func (p *PSCounter) IOReadBytesAvg() uint64 {
-	if len(p.RetIO) == 0 {
-		return 0
-	}
-	var ret uint64
-	for _, v := range p.RetIO {
-		ret += v.ReadBytes
-	}
-	return ret / uint64(len(p.RetIO))
+	return uint64(p.IOReadBytes().Avg())
 }
 
+// Deprecated: use p.IOWriteCount().Min().
 func (p *PSCounter) IOWriteCountMin() uint64 {
-	var ret uint64 = math.MaxUint64
-	for _, v := range p.RetIO {
-		if v.WriteCount < ret {
-			ret = v.WriteCount
-		}
-	}
-	return ret
+	return p.IOWriteCount().Min()
 }
 
+// Deprecated: use p.IOWriteCount().Max().
 func (p *PSCounter) IOWriteCountMax() uint64 {
-	var ret uint64 = 0
-	for _, v := range p.RetIO {
-		if v.WriteCount > ret {
-			ret = v.WriteCount
-		}
-	}
-	return ret
+	return p.IOWriteCount().Max()
 }
 
+// Deprecated: use p.IOWriteCount().Avg().
 func (p *PSCounter) IOWriteCountAvg() uint64 {
-	if len(p.RetIO) == 0 {
-		return 0
-	}
-	var ret uint64
-	for _, v := range p.RetIO {
-		ret += v.WriteCount
-	}
-	return ret / uint64(len(p.RetIO))
+	return uint64(p.IOWriteCount().Avg())
 }
 
+// Deprecated: use p.IOWriteBytes().Min().
 func (p *PSCounter) IOWriteBytesMin() uint64 {
-	var ret uint64 = math.MaxUint64
-	for _, v := range p.RetIO {
-		if v.WriteBytes < ret {
-			ret = v.WriteBytes
-		}
-	}
-	return ret
+	return p.IOWriteBytes().Min()
 }
 
+// Deprecated: use p.IOWriteBytes().Max().
 func (p *PSCounter) IOWriteBytesMax() uint64 {
-	var ret uint64 = 0
-	for _, v := range p.RetIO {
-		if v.WriteBytes > ret {
-			ret = v.WriteBytes
-		}
-	}
-	return ret
+	return p.IOWriteBytes().Max()
 }
 
+// Deprecated: use p.IOWriteBytes().Avg().
 func (p *PSCounter) IOWriteBytesAvg() uint64 {
-	if len(p.RetIO) == 0 {
-		return 0
-	}
-	var ret uint64
-	for _, v := range p.RetIO {
-		ret += v.WriteBytes
-	}
-	return ret / uint64(len(p.RetIO))
+	return uint64(p.IOWriteBytes().Avg())
 }
 
+// Deprecated: use p.NumGoroutine().Min().
 func (p *PSCounter) NumGoroutineMin() int {
-	var ret int
-	if len(p.RetGoroutine) == 1 {
-		return p.RetGoroutine[0]
-	}
-	if len(p.RetGoroutine) > 1 {
-		ret = math.MaxInt
-		for i, v := range p.RetGoroutine {
-			if i > 0 && v < ret {
-				ret = v
-			}
-		}
-	}
-	return ret
+	return p.NumGoroutine().Min()
 }
 
+// Deprecated: use p.NumGoroutine().Max().
 func (p *PSCounter) NumGoroutineMax() int {
-	var ret int
-	for _, v := range p.RetGoroutine {
-		if v > ret {
-			ret = v
-		}
-	}
-	return ret
+	return p.NumGoroutine().Max()
 }
 
+// Deprecated: use p.NumGoroutine().Avg().
 func (p *PSCounter) NumGoroutineAvg() int {
-	if len(p.RetGoroutine) == 0 {
-		return 0
-	}
-	if len(p.RetCPU) == 1 {
-		return p.RetGoroutine[0]
-	}
-	var ret int
-	for i, v := range p.RetGoroutine {
-		if i > 0 {
-			ret += v
-		}
-	}
-	return ret / (len(p.RetCPU) - 1)
+	return int(p.NumGoroutine().Avg())
 }
 
 func (p *PSCounter) String() string {
@@ -0,0 +1,55 @@
+package perf
+
+import (
+	"time"
+)
+
+// CounterRate is the per-second rate of a cumulative counter, one value per
+// sampling interval, as returned by Series.Rate. Total is how much the
+// counter grew over Elapsed, the whole counted window.
+type CounterRate struct {
+	*Series[float64]
+	Total   uint64        `json:"total"`
+	Elapsed time.Duration `json:"elapsed"`
+}
+
+// Avg is Total over Elapsed, which unlike the mean of Values is not skewed
+// by uneven intervals.
+func (r *CounterRate) Avg() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Total) / r.Elapsed.Seconds()
+}
+
+func (p *PSCounter) IOReadCountRate() *CounterRate {
+	return p.IOReadCount().Rate()
+}
+
+func (p *PSCounter) IOWriteCountRate() *CounterRate {
+	return p.IOWriteCount().Rate()
+}
+
+func (p *PSCounter) IOReadBytesRate() *CounterRate {
+	return p.IOReadBytes().Rate()
+}
+
+func (p *PSCounter) IOWriteBytesRate() *CounterRate {
+	return p.IOWriteBytes().Rate()
+}
+
+func (p *PSCounter) NETBytesSentRate(name string) *CounterRate {
+	return p.NETBytesSent(name).Rate()
+}
+
+func (p *PSCounter) NETBytesRecvRate(name string) *CounterRate {
+	return p.NETBytesRecv(name).Rate()
+}
+
+func (p *PSCounter) NETPacketsSentRate(name string) *CounterRate {
+	return p.NETPacketsSent(name).Rate()
+}
+
+func (p *PSCounter) NETPacketsRecvRate(name string) *CounterRate {
+	return p.NETPacketsRecv(name).Rate()
+}
@@ -0,0 +1,141 @@
+package perf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrRemoteRuntime = errors.New("unrecognized runtime endpoint response")
+
+// remoteSampler polls the expvar and pprof endpoints of another Go process.
+// The GC pause quantiles come from the memstats PauseNs ring buffer, which
+// holds the last 256 pauses, scheduler latency is not published remotely.
+type remoteSampler struct {
+	client *http.Client
+	urls   []string
+	numGC  uint32
+	seenGC bool
+}
+
+type remoteMemStats struct {
+	HeapAlloc   uint64
+	HeapInuse   uint64
+	HeapObjects uint64
+	NumGC       uint32
+	PauseNs     [256]uint64
+}
+
+func newRemoteSampler(urls []string, timeout time.Duration) *remoteSampler {
+	return &remoteSampler{
+		client: &http.Client{Timeout: timeout},
+		urls:   urls,
+	}
+}
+
+// sample merges one poll of every url into a RuntimeStat, it fails if any
+// of them fails.
+func (s *remoteSampler) sample(ctx context.Context) (*RuntimeStat, error) {
+	stat := &RuntimeStat{}
+	for _, url := range s.urls {
+		body, err := s.get(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(body, []byte("goroutine profile:")) {
+			err = parseGoroutineProfile(body, stat)
+		} else {
+			err = s.parseExpvar(body, stat)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", url, err)
+		}
+	}
+	return stat, nil
+}
+
+func (s *remoteSampler) get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseGoroutineProfile reads the header of /debug/pprof/goroutine?debug=1.
+func parseGoroutineProfile(body []byte, stat *RuntimeStat) error {
+	line, _, _ := bufio.NewReader(bytes.NewReader(body)).ReadLine()
+	i := bytes.LastIndex(line, []byte("total "))
+	if i < 0 {
+		return ErrRemoteRuntime
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(line[i+len("total "):])))
+	if err != nil {
+		return ErrRemoteRuntime
+	}
+	stat.NumGoroutine = n
+	return nil
+}
+
+// parseExpvar reads /debug/vars. Goroutines are only known if the target
+// publishes them as a "goroutines" var, expvar does not by default.
+func (s *remoteSampler) parseExpvar(body []byte, stat *RuntimeStat) error {
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(body, &vars); err != nil {
+		return ErrRemoteRuntime
+	}
+	raw, ok := vars["memstats"]
+	if !ok {
+		return ErrRemoteRuntime
+	}
+	var ms remoteMemStats
+	if err := json.Unmarshal(raw, &ms); err != nil {
+		return err
+	}
+	if raw, ok := vars["goroutines"]; ok {
+		json.Unmarshal(raw, &stat.NumGoroutine)
+	}
+	stat.HeapAlloc = ms.HeapAlloc
+	stat.HeapInuse = ms.HeapInuse
+	stat.HeapObjects = ms.HeapObjects
+	stat.NumGC = uint64(ms.NumGC)
+
+	// the first poll is the baseline, later ones report the pauses of the
+	// GCs since the previous poll.
+	if s.seenGC && ms.NumGC > s.numGC {
+		n := ms.NumGC - s.numGC
+		if n > uint32(len(ms.PauseNs)) {
+			n = uint32(len(ms.PauseNs))
+		}
+		pauses := make([]time.Duration, 0, n)
+		for i := uint32(0); i < n; i++ {
+			pauses = append(pauses, time.Duration(ms.PauseNs[(ms.NumGC-1-i)%uint32(len(ms.PauseNs))]))
+		}
+		sort.Slice(pauses, func(i, j int) bool {
+			return pauses[i] < pauses[j]
+		})
+		stat.GCPauseP50 = pauses[(len(pauses)-1)/2]
+		stat.GCPauseP99 = pauses[(len(pauses)*99+99)/100-1]
+		stat.GCPauseMax = pauses[len(pauses)-1]
+	}
+	s.numGC = ms.NumGC
+	s.seenGC = true
+	return nil
+}
@@ -0,0 +1,147 @@
+package perf
+
+import (
+	"context"
+	_ "expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	_ "net/http/pprof"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoteSampler(t *testing.T) {
+	srv := httptest.NewServer(http.DefaultServeMux)
+	defer srv.Close()
+
+	s := newRemoteSampler([]string{srv.URL + "/debug/vars", srv.URL + "/debug/pprof/goroutine?debug=1"}, time.Second)
+	first, err := s.sample(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.GCPauseMax != 0 {
+		t.Fatalf("first sample has GC pause %v, want none", first.GCPauseMax)
+	}
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	stat, err := s.sample(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.NumGC < first.NumGC+3 {
+		t.Fatalf("NumGC %v, want at least %v", stat.NumGC, first.NumGC+3)
+	}
+	if stat.GCPauseMax <= 0 || stat.GCPauseP50 > stat.GCPauseP99 || stat.GCPauseP99 > stat.GCPauseMax {
+		t.Fatalf("GC pauses p50 %v p99 %v max %v", stat.GCPauseP50, stat.GCPauseP99, stat.GCPauseMax)
+	}
+	if stat.HeapAlloc == 0 || stat.HeapInuse == 0 || stat.HeapObjects == 0 {
+		t.Fatalf("heap alloc %v inuse %v objects %v", stat.HeapAlloc, stat.HeapInuse, stat.HeapObjects)
+	}
+	if stat.NumGoroutine <= 0 {
+		t.Fatalf("%v goroutines", stat.NumGoroutine)
+	}
+
+	if _, err := newRemoteSampler([]string{srv.URL + "/nope"}, time.Second).sample(context.Background()); err == nil {
+		t.Fatal("expected an error for a 404")
+	}
+}
+
+func memstatsJSON(numGC uint32) string {
+	pauses := make([]string, 256)
+	for i := range pauses {
+		pauses[i] = fmt.Sprint(i + 1)
+	}
+	return fmt.Sprintf(`{"memstats": {"HeapAlloc": 10, "HeapInuse": 20, "HeapObjects": 3, "NumGC": %v, "PauseNs": [%v]}}`,
+		numGC, strings.Join(pauses, ","))
+}
+
+func TestParseExpvarPauseRing(t *testing.T) {
+	// PauseNs[i] is i+1, so every pause names its ring slot.
+	for _, c := range []struct {
+		prev, numGC   uint32
+		p50, p99, max time.Duration
+	}{
+		{290, 300, 39, 44, 44},
+		// slots 3..0 and 255..250, wrapping around the ring.
+		{250, 260, 251, 256, 256},
+		// more GCs than the ring holds report the whole ring.
+		{0, 1000, 128, 254, 256},
+		{300, 300, 0, 0, 0},
+	} {
+		s := &remoteSampler{}
+		stat := &RuntimeStat{}
+		if err := s.parseExpvar([]byte(memstatsJSON(c.prev)), stat); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.parseExpvar([]byte(memstatsJSON(c.numGC)), stat); err != nil {
+			t.Fatal(err)
+		}
+		if stat.GCPauseP50 != c.p50 || stat.GCPauseP99 != c.p99 || stat.GCPauseMax != c.max {
+			t.Fatalf("%v -> %v: p50 %v p99 %v max %v, want %v %v %v", c.prev, c.numGC,
+				int64(stat.GCPauseP50), int64(stat.GCPauseP99), int64(stat.GCPauseMax), int64(c.p50), int64(c.p99), int64(c.max))
+		}
+		if stat.HeapAlloc != 10 || stat.HeapInuse != 20 || stat.HeapObjects != 3 || stat.NumGC != uint64(c.numGC) {
+			t.Fatalf("%+v", stat)
+		}
+	}
+
+	stat := &RuntimeStat{}
+	if err := (&remoteSampler{}).parseExpvar([]byte(`{"cmdline": [], "goroutines": 7, "memstats": {}}`), stat); err != nil || stat.NumGoroutine != 7 {
+		t.Fatalf("goroutines %v %v, want 7", stat.NumGoroutine, err)
+	}
+	for _, body := range []string{`{"cmdline": []}`, `not json`} {
+		if err := (&remoteSampler{}).parseExpvar([]byte(body), &RuntimeStat{}); err != ErrRemoteRuntime {
+			t.Fatalf("%v: got %v, want ErrRemoteRuntime", body, err)
+		}
+	}
+}
+
+func TestParseGoroutineProfile(t *testing.T) {
+	stat := &RuntimeStat{}
+	if err := parseGoroutineProfile([]byte("goroutine profile: total 42\n5 @ 0x1 0x2\n"), stat); err != nil || stat.NumGoroutine != 42 {
+		t.Fatalf("got %v %v, want 42", stat.NumGoroutine, err)
+	}
+	for _, body := range []string{"goroutine profile: total x\n", "goroutine profile:\n"} {
+		if err := parseGoroutineProfile([]byte(body), &RuntimeStat{}); err != ErrRemoteRuntime {
+			t.Fatalf("%q: got %v, want ErrRemoteRuntime", body, err)
+		}
+	}
+}
+
+func TestPSCounterRuntimeURLs(t *testing.T) {
+	srv := httptest.NewServer(http.DefaultServeMux)
+	defer srv.Close()
+
+	p, err := NewPSCounter(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start(PSCountOptions{
+		CountMEM:     true,
+		CountRuntime: true,
+		Interval:     100 * time.Millisecond,
+		RuntimeURLs:  []string{srv.URL + "/debug/vars", srv.URL + "/debug/pprof/goroutine?debug=1"},
+	})
+	time.Sleep(500 * time.Millisecond)
+	p.Stop()
+	if p.HeapAlloc().Len() == 0 || p.HeapAlloc().Min() == 0 {
+		t.Fatalf("%v heap samples, min %v", p.HeapAlloc().Len(), p.HeapAlloc().Min())
+	}
+	if g := RuntimeSeries(p, func(v *RuntimeStat) int { return v.NumGoroutine }); g.Min() <= 0 {
+		t.Fatalf("goroutines %v", g.Values)
+	}
+	if p.MEMRSS().Len() == 0 {
+		t.Fatal("no OS samples next to the runtime ones")
+	}
+
+	p.Start(PSCountOptions{CountRuntime: true, Interval: 20 * time.Millisecond, RuntimeURLs: []string{srv.URL + "/nope"}})
+	time.Sleep(100 * time.Millisecond)
+	p.Stop()
+	if n := len(p.RetRuntime); n != 0 {
+		t.Fatalf("%v samples from a failing endpoint", n)
+	}
+}
@@ -0,0 +1,183 @@
+package perf
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"time"
+)
+
+// RuntimeStat is one sample of the Go runtime of the current process, or of
+// a remote one, see PSCountOptions.RuntimeURLs. The pause and latency
+// quantiles cover the interval since the previous sample.
+type RuntimeStat struct {
+	NumGoroutine    int           `json:"goroutines"`
+	HeapAlloc       uint64        `json:"heap_alloc"`
+	HeapInuse       uint64        `json:"heap_inuse"`
+	HeapObjects     uint64        `json:"heap_objects"`
+	NumGC           uint64        `json:"num_gc"`
+	GCPauseP50      time.Duration `json:"gc_pause_p50"`
+	GCPauseP99      time.Duration `json:"gc_pause_p99"`
+	GCPauseMax      time.Duration `json:"gc_pause_max"`
+	SchedLatencyP50 time.Duration `json:"sched_latency_p50"`
+	SchedLatencyP99 time.Duration `json:"sched_latency_p99"`
+	SchedLatencyMax time.Duration `json:"sched_latency_max"`
+}
+
+const (
+	metricHeapObjects = "/memory/classes/heap/objects:bytes"
+	metricHeapUnused  = "/memory/classes/heap/unused:bytes"
+	metricObjects     = "/gc/heap/objects:objects"
+	metricGCCycles    = "/gc/cycles/total:gc-cycles"
+	metricSchedLat    = "/sched/latencies:seconds"
+)
+
+// metricGCPauses lists the GC pause histogram by preference, the first one
+// was added in go1.22 and replaces the second.
+var metricGCPauses = []string{"/sched/pauses/total/gc:seconds", "/gc/pauses:seconds"}
+
+// runtimeSampler reads runtime/metrics, skipping metrics the running Go
+// version does not support, and keeps the previous histograms so every
+// sample reports its own interval.
+type runtimeSampler struct {
+	samples    []metrics.Sample
+	index      map[string]int
+	gcPause    string
+	prevPauses []uint64
+	prevSched  []uint64
+}
+
+func newRuntimeSampler() *runtimeSampler {
+	supported := map[string]bool{}
+	for _, d := range metrics.All() {
+		supported[d.Name] = true
+	}
+
+	s := &runtimeSampler{index: map[string]int{}}
+	names := []string{metricHeapObjects, metricHeapUnused, metricObjects, metricGCCycles, metricSchedLat}
+	for _, name := range metricGCPauses {
+		if supported[name] {
+			s.gcPause = name
+			names = append(names, name)
+			break
+		}
+	}
+	for _, name := range names {
+		if supported[name] {
+			s.index[name] = len(s.samples)
+			s.samples = append(s.samples, metrics.Sample{Name: name})
+		}
+	}
+	// the first sample's quantiles cover the interval since this call.
+	s.sample()
+	return s
+}
+
+func (s *runtimeSampler) uint64(name string) uint64 {
+	i, ok := s.index[name]
+	if !ok || s.samples[i].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s.samples[i].Value.Uint64()
+}
+
+// quantiles returns p50, p99 and max of the histogram's growth since prev
+// and the new counts to pass as prev next time.
+func (s *runtimeSampler) quantiles(name string, prev []uint64) (p50, p99, max time.Duration, counts []uint64) {
+	i, ok := s.index[name]
+	if !ok || s.samples[i].Value.Kind() != metrics.KindFloat64Histogram {
+		return 0, 0, 0, nil
+	}
+	h := s.samples[i].Value.Float64Histogram()
+	counts = append([]uint64{}, h.Counts...)
+
+	delta := make([]uint64, len(counts))
+	var total uint64
+	for j, n := range counts {
+		if len(prev) == len(counts) && n >= prev[j] {
+			n -= prev[j]
+		}
+		delta[j] = n
+		total += n
+	}
+	if total == 0 {
+		return 0, 0, 0, counts
+	}
+
+	// a bucket is reported by its upper bound, unless that is +Inf.
+	bound := func(j int) time.Duration {
+		v := h.Buckets[j+1]
+		if math.IsInf(v, 1) {
+			v = h.Buckets[j]
+		}
+		return time.Duration(v * float64(time.Second))
+	}
+	var cnt uint64
+	for j, n := range delta {
+		if n == 0 {
+			continue
+		}
+		cnt += n
+		if p50 == 0 && float64(cnt) >= 0.5*float64(total) {
+			p50 = bound(j)
+		}
+		if p99 == 0 && float64(cnt) >= 0.99*float64(total) {
+			p99 = bound(j)
+		}
+		max = bound(j)
+	}
+	return p50, p99, max, counts
+}
+
+func (s *runtimeSampler) sample() *RuntimeStat {
+	metrics.Read(s.samples)
+	stat := &RuntimeStat{
+		NumGoroutine: runtime.NumGoroutine(),
+		HeapAlloc:    s.uint64(metricHeapObjects),
+		HeapInuse:    s.uint64(metricHeapObjects) + s.uint64(metricHeapUnused),
+		HeapObjects:  s.uint64(metricObjects),
+		NumGC:        s.uint64(metricGCCycles),
+	}
+	stat.GCPauseP50, stat.GCPauseP99, stat.GCPauseMax, s.prevPauses = s.quantiles(s.gcPause, s.prevPauses)
+	stat.SchedLatencyP50, stat.SchedLatencyP99, stat.SchedLatencyMax, s.prevSched = s.quantiles(metricSchedLat, s.prevSched)
+	return stat
+}
+
+// RuntimeSeries builds a Series from one field of RetRuntime, for fields
+// without an accessor of their own.
+func RuntimeSeries[T Number](p *PSCounter, get func(*RuntimeStat) T) *Series[T] {
+	return seriesOf(p.RetRuntime, p.TimeRuntime, get)
+}
+
+func (p *PSCounter) HeapAlloc() *Series[uint64] {
+	return RuntimeSeries(p, func(v *RuntimeStat) uint64 { return v.HeapAlloc })
+}
+
+func (p *PSCounter) HeapInuse() *Series[uint64] {
+	return RuntimeSeries(p, func(v *RuntimeStat) uint64 { return v.HeapInuse })
+}
+
+func (p *PSCounter) HeapObjects() *Series[uint64] {
+	return RuntimeSeries(p, func(v *RuntimeStat) uint64 { return v.HeapObjects })
+}
+
+// NumGC is cumulative, NumGC().Rate() gives GC cycles per second.
+func (p *PSCounter) NumGC() *Series[uint64] {
+	return RuntimeSeries(p, func(v *RuntimeStat) uint64 { return v.NumGC })
+}
+
+func (p *PSCounter) GCPauseP99() *Series[time.Duration] {
+	return RuntimeSeries(p, func(v *RuntimeStat) time.Duration { return v.GCPauseP99 })
+}
+
+func (p *PSCounter) GCPauseMax() *Series[time.Duration] {
+	return RuntimeSeries(p, func(v *RuntimeStat) time.Duration { return v.GCPauseMax })
+}
+
+func (p *PSCounter) SchedLatencyP99() *Series[time.Duration] {
+	return RuntimeSeries(p, func(v *RuntimeStat) time.Duration { return v.SchedLatencyP99 })
+}
+
+func (p *PSCounter) SchedLatencyMax() *Series[time.Duration] {
+	return RuntimeSeries(p, func(v *RuntimeStat) time.Duration { return v.SchedLatencyMax })
+}
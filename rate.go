@@ -0,0 +1,84 @@
+package perf
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+type Arrival int
+
+const (
+	ArrivalConstant Arrival = iota
+	ArrivalPoisson
+)
+
+func (a Arrival) String() string {
+	switch a {
+	case ArrivalPoisson:
+		return "poisson"
+	default:
+		return "constant"
+	}
+}
+
+func parseArrival(s string) Arrival {
+	if s == "poisson" {
+		return ArrivalPoisson
+	}
+	return ArrivalConstant
+}
+
+type arrival struct {
+	cnt      int
+	stage    int
+	intended time.Time
+}
+
+func (r *runner) setRate(rate float64) {
+	atomic.StoreUint64(&r.rate, math.Float64bits(rate))
+}
+
+func (r *runner) currentRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&r.rate))
+}
+
+// schedule emits intended send times at the current rate until opt.Times is
+// reached or the run stops. Send times are derived from the schedule rather
+// than from when the previous call was taken, so a stalled worker pool shows
+// up as latency instead of being hidden.
+func (r *runner) schedule(arrivals chan<- arrival) {
+	defer r.wg.Done()
+	defer close(arrivals)
+	defer r.stop()
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	next := time.Now()
+	for cnt := 1; r.opt.Times <= 0 || cnt <= r.opt.Times; cnt++ {
+		rate := r.currentRate()
+		for rate <= 0 {
+			if !r.sleep(idleStep) {
+				return
+			}
+			rate = r.currentRate()
+			next = time.Now()
+		}
+		if d := time.Until(next); d > 0 && !r.sleep(d) {
+			return
+		}
+		a := arrival{cnt: cnt, stage: int(atomic.LoadInt32(&r.stage)), intended: next}
+		select {
+		case <-r.done:
+			return
+		case arrivals <- a:
+		}
+		interval := float64(time.Second) / rate
+		switch r.opt.Arrival {
+		case ArrivalPoisson:
+			next = next.Add(time.Duration(rnd.ExpFloat64() * interval))
+		default:
+			next = next.Add(time.Duration(interval))
+		}
+	}
+}
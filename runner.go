@@ -0,0 +1,463 @@
+package perf
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	idleStep        = time.Millisecond * 10
+	interpolateStep = time.Millisecond * 100
+)
+
+type Stage struct {
+	Concurrent int           `json:"concurrent,omitempty"`
+	Rate       float64       `json:"rate,omitempty"`
+	Duration   time.Duration `json:"duration"`
+}
+
+type window struct {
+	begin time.Time
+	end   time.Time
+}
+
+func (w window) used() time.Duration {
+	if w.begin.IsZero() || w.end.Before(w.begin) {
+		return 0
+	}
+	return w.end.Sub(w.begin)
+}
+
+func (opt BenchmarkOptions) openLoop() bool {
+	if opt.Rate > 0 {
+		return true
+	}
+	for _, st := range opt.Stages {
+		if st.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (opt BenchmarkOptions) maxConcurrent() int {
+	n := opt.Concurrent
+	for _, st := range opt.Stages {
+		if st.Concurrent > n {
+			n = st.Concurrent
+		}
+	}
+	return n
+}
+
+// runner drives the workers of one benchmark. Workers with an id at or above
+// the current concurrency target park until the target rises again, so
+// stages can grow and shrink the pool without restarting it.
+type runner struct {
+	ctx      context.Context
+	opt      BenchmarkOptions
+	openLoop bool
+	executor func(worker, cnt, stage int, intended time.Time)
+
+	total      uint64
+	stopped    int32
+	stage      int32
+	concurrent int32
+	rate       uint64
+
+	mux      sync.Mutex
+	cond     *sync.Cond
+	spawned  int
+	wg       sync.WaitGroup
+	done     chan struct{}
+	stopOnce sync.Once
+	arrivals chan arrival
+
+	windows []window
+
+	// disabled workers return right away, e.g. after a failed Setup.
+	disabled []bool
+}
+
+func newRunner(ctx context.Context, opt BenchmarkOptions, executor func(worker, cnt, stage int, intended time.Time)) *runner {
+	if opt.Concurrent <= 0 {
+		opt.Concurrent = 1
+	}
+	r := &runner{
+		ctx:      ctx,
+		opt:      opt,
+		openLoop: opt.openLoop(),
+		executor: executor,
+		done:     make(chan struct{}),
+		windows:  make([]window, len(opt.Stages)),
+	}
+	r.cond = sync.NewCond(&r.mux)
+	return r
+}
+
+func (r *runner) run() {
+	opt := r.opt
+	// a ctx that can be cancelled is a stop condition of its own.
+	if opt.Times <= 0 && opt.Duration <= 0 && len(opt.Stages) == 0 && r.ctx.Done() == nil {
+		return
+	}
+	// with every worker disabled nothing would take the scheduled arrivals.
+	if r.allDisabled() {
+		return
+	}
+
+	if opt.Duration > 0 {
+		timer := time.AfterFunc(opt.Duration, r.stop)
+		defer timer.Stop()
+	}
+
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			r.stop()
+		case <-r.done:
+		}
+	}()
+	defer r.stop()
+
+	if r.openLoop {
+		r.arrivals = make(chan arrival)
+		r.wg.Add(1)
+		go r.schedule(r.arrivals)
+	}
+
+	if len(opt.Stages) > 0 {
+		r.wg.Add(1)
+		go r.control()
+	} else {
+		r.setRate(opt.Rate)
+		r.setConcurrent(opt.Concurrent)
+	}
+
+	r.wg.Wait()
+}
+
+func (r *runner) allDisabled() bool {
+	if len(r.disabled) == 0 {
+		return false
+	}
+	for _, v := range r.disabled {
+		if !v {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *runner) stop() {
+	r.stopOnce.Do(func() {
+		r.mux.Lock()
+		atomic.StoreInt32(&r.stopped, 1)
+		close(r.done)
+		r.cond.Broadcast()
+		r.mux.Unlock()
+	})
+}
+
+func (r *runner) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-r.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (r *runner) setConcurrent(n int) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if atomic.LoadInt32(&r.stopped) != 0 {
+		return
+	}
+	atomic.StoreInt32(&r.concurrent, int32(n))
+	for ; r.spawned < n; r.spawned++ {
+		r.wg.Add(1)
+		go r.worker(r.spawned)
+	}
+	r.cond.Broadcast()
+}
+
+func (r *runner) wait(worker int) bool {
+	if atomic.LoadInt32(&r.stopped) != 0 {
+		return false
+	}
+	if worker < int(atomic.LoadInt32(&r.concurrent)) {
+		return true
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	for worker >= int(atomic.LoadInt32(&r.concurrent)) && atomic.LoadInt32(&r.stopped) == 0 {
+		r.cond.Wait()
+	}
+	return atomic.LoadInt32(&r.stopped) == 0
+}
+
+func (r *runner) worker(worker int) {
+	defer r.wg.Done()
+	if worker < len(r.disabled) && r.disabled[worker] {
+		return
+	}
+	for r.wait(worker) {
+		if r.openLoop {
+			a, ok := <-r.arrivals
+			if !ok {
+				return
+			}
+			r.executor(worker, a.cnt, a.stage, a.intended)
+			continue
+		}
+		cnt := int(atomic.AddUint64(&r.total, 1))
+		if r.opt.Times > 0 && cnt > r.opt.Times {
+			r.stop()
+			return
+		}
+		r.executor(worker, cnt, int(atomic.LoadInt32(&r.stage)), time.Time{})
+	}
+}
+
+func (r *runner) stageConcurrent(st Stage) int {
+	if r.openLoop && st.Concurrent <= 0 {
+		return r.opt.Concurrent
+	}
+	return st.Concurrent
+}
+
+func (r *runner) control() {
+	defer r.wg.Done()
+	defer r.stop()
+
+	var (
+		prevConcurrent int
+		prevRate       float64
+	)
+	for i, st := range r.opt.Stages {
+		concurrent := r.stageConcurrent(st)
+		begin := time.Now()
+		end := begin.Add(st.Duration)
+		atomic.StoreInt32(&r.stage, int32(i))
+		r.windows[i].begin = begin
+		for now := begin; now.Before(end); now = time.Now() {
+			step := end.Sub(now)
+			if r.opt.Interpolate {
+				frac := float64(now.Sub(begin)) / float64(st.Duration)
+				if r.openLoop {
+					r.setConcurrent(concurrent)
+				} else {
+					r.setConcurrent(prevConcurrent + int(math.Round(float64(concurrent-prevConcurrent)*frac)))
+				}
+				r.setRate(prevRate + (st.Rate-prevRate)*frac)
+				// short stages still move in about ten steps.
+				limit := interpolateStep
+				if d := st.Duration / 10; d < limit {
+					limit = d
+				}
+				if step > limit {
+					step = limit
+				}
+			} else {
+				r.setConcurrent(concurrent)
+				r.setRate(st.Rate)
+			}
+			if !r.sleep(step) {
+				break
+			}
+		}
+		r.windows[i].end = time.Now()
+		if atomic.LoadInt32(&r.stopped) != 0 {
+			return
+		}
+		prevConcurrent, prevRate = concurrent, st.Rate
+	}
+}
+
+type stats struct {
+	openLoop    bool
+	keepCost    bool
+	success     int64
+	failed      int64
+	mux         sync.Mutex
+	errors      map[string]int
+	costs       [][]int64
+	uncorrected [][]int64
+
+	// with opt.Histogram, latencies go to a few shared histograms instead of
+	// costs; workers are spread over them to keep contention low.
+	hists            []*Histogram
+	uncorrectedHists []*Histogram
+}
+
+// histogramShards spreads min(workers, GOMAXPROCS) histogram shards over
+// the n stats of one run, so the memory of opt.Histogram does not grow with
+// the number of stages and operations.
+func histogramShards(workers, n int) int {
+	shards := runtime.GOMAXPROCS(0)
+	if shards > workers {
+		shards = workers
+	}
+	if n > 1 {
+		shards /= n
+	}
+	if shards < 1 {
+		shards = 1
+	}
+	return shards
+}
+
+// shards is the number of histograms with opt.Histogram, see histogramShards.
+func newStats(opt BenchmarkOptions, workers, times, shards int) *stats {
+	s := &stats{
+		openLoop: opt.openLoop(),
+		keepCost: len(opt.Percents) > 0,
+		errors:   map[string]int{},
+	}
+
+	if opt.Histogram {
+		digits := opt.HistogramDigits
+		if digits <= 0 {
+			digits = DefaultHistogramDigits
+		}
+		s.hists = newHistograms(shards, digits)
+		if s.openLoop {
+			s.uncorrectedHists = newHistograms(shards, digits)
+		}
+		return s
+	}
+
+	if s.keepCost {
+		s.costs = make([][]int64, workers)
+		if s.openLoop {
+			s.uncorrected = make([][]int64, workers)
+		}
+		if times > 0 {
+			for i := range s.costs {
+				s.costs[i] = make([]int64, 0, times/workers+1)
+				if s.openLoop {
+					s.uncorrected[i] = make([]int64, 0, times/workers+1)
+				}
+			}
+		}
+	}
+	return s
+}
+
+func newHistograms(n, digits int) []*Histogram {
+	hists := make([]*Histogram, n)
+	for i := range hists {
+		hists[i] = NewHistogram(DefaultHistogramMax, digits)
+	}
+	return hists
+}
+
+// add must only be called by the worker that owns the given id.
+func (s *stats) add(worker int, begin, end, intended time.Time, err error) {
+	if err != nil {
+		atomic.AddInt64(&s.failed, 1)
+		s.mux.Lock()
+		errStr := err.Error()
+		errCnt := s.errors[errStr]
+		s.errors[errStr] = errCnt + 1
+		s.mux.Unlock()
+		if s.keepCost && s.hists == nil {
+			s.costs[worker] = append(s.costs[worker], -1)
+			if s.openLoop {
+				s.uncorrected[worker] = append(s.uncorrected[worker], -1)
+			}
+		}
+		return
+	}
+
+	atomic.AddInt64(&s.success, 1)
+
+	cost := end.Sub(begin).Nanoseconds()
+	if s.hists != nil {
+		if s.openLoop {
+			s.hists[worker%len(s.hists)].Record(end.Sub(intended).Nanoseconds())
+			s.uncorrectedHists[worker%len(s.hists)].Record(cost)
+		} else {
+			s.hists[worker%len(s.hists)].Record(cost)
+		}
+		return
+	}
+	if s.keepCost {
+		if s.openLoop {
+			s.costs[worker] = append(s.costs[worker], end.Sub(intended).Nanoseconds())
+			s.uncorrected[worker] = append(s.uncorrected[worker], cost)
+		} else {
+			s.costs[worker] = append(s.costs[worker], cost)
+		}
+	}
+}
+
+func (s *stats) flush(c *Calculator, used time.Duration, percents []int) {
+	c.Success = s.success
+	c.Failed = s.failed
+	c.Total = int(s.success + s.failed)
+	c.FailedErrors = s.errors
+	c.Used = used
+	c.Cost = nil
+	c.Uncorrected = nil
+	c.Hist = nil
+	c.UncorrectedHist = nil
+	if s.hists != nil {
+		c.Hist = mergeHistograms(s.hists)
+		if s.openLoop {
+			c.UncorrectedHist = mergeHistograms(s.uncorrectedHists)
+		}
+	} else {
+		c.Cost = mergeCost(s.costs)
+		if s.openLoop {
+			c.Uncorrected = mergeCost(s.uncorrected)
+		}
+	}
+	c.result = ""
+	c.calculate(percents)
+}
+
+func mergeStats(all []*stats) *stats {
+	s := &stats{errors: map[string]int{}}
+	for _, v := range all {
+		s.openLoop = v.openLoop
+		s.keepCost = v.keepCost
+		s.success += v.success
+		s.failed += v.failed
+		for k, n := range v.errors {
+			s.errors[k] += n
+		}
+		s.costs = append(s.costs, v.costs...)
+		s.uncorrected = append(s.uncorrected, v.uncorrected...)
+		s.hists = append(s.hists, v.hists...)
+		s.uncorrectedHists = append(s.uncorrectedHists, v.uncorrectedHists...)
+	}
+	return s
+}
+
+func mergeHistograms(hists []*Histogram) *Histogram {
+	h := NewHistogram(hists[0].Highest(), hists[0].Digits())
+	for _, v := range hists {
+		h.Merge(v)
+	}
+	return h
+}
+
+func mergeCost(costs [][]int64) []int64 {
+	n := 0
+	for _, v := range costs {
+		n += len(v)
+	}
+	cost := make([]int64, 0, n)
+	for _, v := range costs {
+		cost = append(cost, v...)
+	}
+	return cost
+}
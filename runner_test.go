@@ -0,0 +1,119 @@
+package perf
+
+import (
+	"testing"
+	"time"
+)
+
+// fixedLatency is an executor that takes d per call.
+func fixedLatency(d time.Duration) func() error {
+	return func() error {
+		time.Sleep(d)
+		return nil
+	}
+}
+
+func TestRunnerStops(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opt  BenchmarkOptions
+	}{
+		{"closed times", BenchmarkOptions{Concurrent: 4, Times: 100}},
+		{"closed duration", BenchmarkOptions{Concurrent: 4, Duration: 200 * time.Millisecond}},
+		{"open times", BenchmarkOptions{Concurrent: 4, Times: 100, Rate: 1000}},
+		{"open duration", BenchmarkOptions{Concurrent: 4, Duration: 200 * time.Millisecond, Rate: 1000}},
+	} {
+		for _, histogram := range []bool{false, true} {
+			opt := c.opt
+			opt.Histogram = histogram
+			calc := NewCalculator(c.name)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				calc.BenchmarkWithOptions(opt, fixedLatency(time.Millisecond))
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%v: did not stop", c.name)
+			}
+
+			if calc.Failed != 0 || int64(calc.Total) != calc.Success {
+				t.Fatalf("%v: total %v, success %v, failed %v", c.name, calc.Total, calc.Success, calc.Failed)
+			}
+			if opt.Times > 0 && calc.Total != opt.Times {
+				t.Fatalf("%v: total %v, want %v", c.name, calc.Total, opt.Times)
+			}
+			if opt.Duration > 0 && (calc.Used < opt.Duration || calc.Used > opt.Duration+time.Second) {
+				t.Fatalf("%v: used %v, want about %v", c.name, calc.Used, opt.Duration)
+			}
+		}
+	}
+}
+
+func TestRunnerCoordinatedOmission(t *testing.T) {
+	// 2 workers at 10ms a call serve 200/s, far below the rate, so calls
+	// queue behind the schedule.
+	for _, histogram := range []bool{false, true} {
+		c := NewCalculator("open")
+		c.BenchmarkWithOptions(BenchmarkOptions{
+			Concurrent: 2,
+			Rate:       1000,
+			Duration:   300 * time.Millisecond,
+			Histogram:  histogram,
+			Percents:   []int{50, 99},
+		}, fixedLatency(10*time.Millisecond))
+
+		if c.Total == 0 || c.TPS() > 400 {
+			t.Fatalf("total %v, TPS %v, want the pool saturated", c.Total, c.TPS())
+		}
+		corrected, uncorrected := c.TPN(99), c.TPNUncorrected(99)
+		if uncorrected < int64(10*time.Millisecond) || uncorrected > int64(50*time.Millisecond) {
+			t.Fatalf("uncorrected TP99 %v, want about the 10ms a call takes", time.Duration(uncorrected))
+		}
+		if corrected < 3*uncorrected {
+			t.Fatalf("corrected TP99 %v, uncorrected %v, want the queueing delay included",
+				time.Duration(corrected), time.Duration(uncorrected))
+		}
+	}
+}
+
+func TestRunnerStageTotals(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		stages []Stage
+	}{
+		{"closed", []Stage{
+			{Concurrent: 2, Duration: 100 * time.Millisecond},
+			{Concurrent: 4, Duration: 100 * time.Millisecond},
+			{Concurrent: 1, Duration: 100 * time.Millisecond},
+		}},
+		{"open", []Stage{
+			{Concurrent: 4, Rate: 200, Duration: 100 * time.Millisecond},
+			{Concurrent: 4, Rate: 800, Duration: 100 * time.Millisecond},
+			{Concurrent: 4, Rate: 100, Duration: 100 * time.Millisecond},
+		}},
+	} {
+		for _, interpolate := range []bool{false, true} {
+			calc := NewCalculator(c.name)
+			calc.BenchmarkWithOptions(BenchmarkOptions{Stages: c.stages, Interpolate: interpolate}, fixedLatency(time.Millisecond))
+			if len(calc.Stages) != len(c.stages) {
+				t.Fatalf("%v: %v stages, want %v", c.name, len(calc.Stages), len(c.stages))
+			}
+			var (
+				total   int
+				success int64
+			)
+			for _, s := range calc.Stages {
+				if s.Total == 0 {
+					t.Fatalf("%v: empty stage %v", c.name, s.Name)
+				}
+				total += s.Total
+				success += s.Success
+			}
+			if total != calc.Total || success != calc.Success {
+				t.Fatalf("%v: stages sum to %v/%v, run is %v/%v", c.name, total, success, calc.Total, calc.Success)
+			}
+		}
+	}
+}
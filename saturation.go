@@ -0,0 +1,250 @@
+package perf
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+type SearchMode int
+
+const (
+	SearchRate SearchMode = iota
+	SearchConcurrent
+)
+
+func (m SearchMode) String() string {
+	if m == SearchConcurrent {
+		return "concurrent"
+	}
+	return "rate"
+}
+
+func (m SearchMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// SLO is met by a probe when its TP<Percent> is at most Latency and at most
+// ErrorRate (a fraction, e.g. 0.01) of its calls failed.
+type SLO struct {
+	Percent   int           `json:"percent"`
+	Latency   time.Duration `json:"latency"`
+	ErrorRate float64       `json:"error_rate"`
+}
+
+type SearchOptions struct {
+	SLO  SLO
+	Mode SearchMode
+
+	// Start is the first probe's rate or concurrency, 100 or 1 if zero.
+	// Probes grow by Factor (2 if zero) until the SLO breaks or Max
+	// (unlimited if zero) is reached, then the knee is narrowed down by
+	// bisection until the bounds are within Precision (0.05 if zero)
+	// of each other. No more than MaxProbes (20 if zero) are run.
+	Start     float64
+	Max       float64
+	Factor    float64
+	Precision float64
+	MaxProbes int
+
+	// ProbeDuration is how long each probe runs, 5s if zero.
+	ProbeDuration time.Duration
+	Cooldown      time.Duration
+
+	// Concurrent is the worker pool of rate probes, 100 if zero.
+	Concurrent int
+	Arrival    Arrival
+	Timeout    time.Duration
+	Percents   []int
+}
+
+type Probe struct {
+	Value     float64     `json:"value"`
+	TPS       int64       `json:"tps"`
+	Latency   int64       `json:"latency"`
+	ErrorRate float64     `json:"error_rate"`
+	Pass      bool        `json:"pass"`
+	Result    *Calculator `json:"-"`
+}
+
+// Saturation is the result of SearchSaturation. Knee is the passing probe
+// with the highest rate or concurrency, nil if even the lowest one failed.
+// Probes are in the order they were run, Curve sorts them by Value.
+type Saturation struct {
+	Name   string       `json:"name"`
+	Mode   SearchMode   `json:"mode"`
+	SLO    SLO          `json:"slo"`
+	Probes []*Probe     `json:"probes"`
+	Knee   *Probe       `json:"knee"`
+	Env    *Environment `json:"env"`
+}
+
+// SearchSaturation looks for the highest rate (or concurrency) at which
+// executor still meets opt.SLO. Rate probes are open-loop, so latency
+// includes the queueing of an overloaded target.
+func SearchSaturation(ctx context.Context, name string, opt SearchOptions, executor Executor) *Saturation {
+	if opt.Start <= 0 {
+		opt.Start = 100
+		if opt.Mode == SearchConcurrent {
+			opt.Start = 1
+		}
+	}
+	if opt.Factor <= 1 {
+		opt.Factor = 2
+	}
+	if opt.Precision <= 0 {
+		opt.Precision = 0.05
+	}
+	if opt.MaxProbes <= 0 {
+		opt.MaxProbes = 20
+	}
+	if opt.ProbeDuration <= 0 {
+		opt.ProbeDuration = 5 * time.Second
+	}
+	if opt.Concurrent <= 0 {
+		opt.Concurrent = 100
+	}
+	if opt.SLO.Percent <= 0 {
+		opt.SLO.Percent = 99
+	}
+	percents := append([]int{}, opt.Percents...)
+	found := false
+	for _, k := range percents {
+		if k == opt.SLO.Percent {
+			found = true
+		}
+	}
+	if !found {
+		percents = append(percents, opt.SLO.Percent)
+	}
+
+	s := &Saturation{Name: name, Mode: opt.Mode, SLO: opt.SLO, Env: CaptureEnvironment()}
+	probe := func(v float64) *Probe {
+		if len(s.Probes) > 0 && opt.Cooldown > 0 {
+			time.Sleep(opt.Cooldown)
+		}
+		bopt := BenchmarkOptions{
+			Concurrent: opt.Concurrent,
+			Duration:   opt.ProbeDuration,
+			Arrival:    opt.Arrival,
+			Timeout:    opt.Timeout,
+			Percents:   percents,
+			Histogram:  true,
+		}
+		if opt.Mode == SearchConcurrent {
+			bopt.Concurrent = int(v)
+		} else {
+			bopt.Rate = v
+		}
+		c := NewCalculator(fmt.Sprintf("%v-%v-%v", name, opt.Mode, v))
+		c.BenchmarkExecutor(ctx, bopt, executor)
+
+		p := &Probe{
+			Value:   v,
+			TPS:     c.TPS(),
+			Latency: c.TPN(opt.SLO.Percent),
+			Result:  c,
+		}
+		if c.Total > 0 {
+			p.ErrorRate = float64(c.Failed) / float64(c.Total)
+		}
+		p.Pass = c.Total > 0 && p.ErrorRate <= opt.SLO.ErrorRate && p.Latency <= int64(opt.SLO.Latency)
+		s.Probes = append(s.Probes, p)
+		if p.Pass && (s.Knee == nil || v > s.Knee.Value) {
+			s.Knee = p
+		}
+		return p
+	}
+	// concurrency is a whole number, rates are rounded unless below one.
+	round := func(v float64) float64 {
+		if opt.Mode == SearchRate && v < 1 {
+			return v
+		}
+		return math.Round(v)
+	}
+
+	// grow until the SLO breaks.
+	var lo, hi float64
+	for v := round(opt.Start); len(s.Probes) < opt.MaxProbes && ctx.Err() == nil; {
+		if !probe(v).Pass {
+			hi = v
+			break
+		}
+		lo = v
+		if opt.Max > 0 && v >= opt.Max {
+			break
+		}
+		next := round(v * opt.Factor)
+		if opt.Max > 0 && next > opt.Max {
+			next = opt.Max
+		}
+		v = next
+	}
+
+	// then bisect between the last passing and the first failing probe.
+	for hi > 0 && len(s.Probes) < opt.MaxProbes && ctx.Err() == nil {
+		if lo > 0 && (hi-lo)/lo <= opt.Precision {
+			break
+		}
+		mid := round((lo + hi) / 2)
+		if mid <= lo || mid >= hi {
+			break
+		}
+		if probe(mid).Pass {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return s
+}
+
+func (s *Saturation) Curve() []*Probe {
+	curve := append([]*Probe{}, s.Probes...)
+	sort.SliceStable(curve, func(i, j int) bool {
+		return curve[i].Value < curve[j].Value
+	})
+	return curve
+}
+
+// Table renders the curve, the knee is marked with "*".
+func (s *Saturation) Table() *Table {
+	t := NewTable()
+	mode := "Rate"
+	if s.Mode == SearchConcurrent {
+		mode = "Concurrent"
+	}
+	t.SetTitle([]string{mode, "TPS", fmt.Sprintf("TP%v", s.SLO.Percent), "Errors", "SLO"})
+	for _, p := range s.Curve() {
+		value := fmt.Sprintf("%v", p.Value)
+		if p == s.Knee {
+			value += "*"
+		}
+		pass := "pass"
+		if !p.Pass {
+			pass = "fail"
+		}
+		t.AddRow([]string{
+			value,
+			fmt.Sprintf("%v", p.TPS),
+			I2TimeString(p.Latency),
+			fmt.Sprintf("%.2f%%", p.ErrorRate*100),
+			pass,
+		})
+	}
+	return t
+}
+
+func (s *Saturation) String() string {
+	str := fmt.Sprintf("SLO      : TP%v <= %v, errors <= %.2f%%\n",
+		s.SLO.Percent, I2TimeString(int64(s.SLO.Latency)), s.SLO.ErrorRate*100)
+	if s.Knee != nil {
+		str += fmt.Sprintf("KNEE     : %v %v, TPS %v, TP%v %v\n",
+			s.Mode, s.Knee.Value, s.Knee.TPS, s.SLO.Percent, I2TimeString(s.Knee.Latency))
+	} else {
+		str += "KNEE     : none, the first probe already failed\n"
+	}
+	return str + s.Table().Markdown()
+}
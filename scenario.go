@@ -0,0 +1,130 @@
+package perf
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+type Operation struct {
+	Name     string
+	Weight   float64
+	Executor Executor
+}
+
+// Scenario is a weighted mix of operations sharing one worker pool. Each
+// call picks an operation with probability Weight / sum(Weight).
+type Scenario struct {
+	Operations []Operation
+}
+
+func NewScenario() *Scenario {
+	return &Scenario{}
+}
+
+func (s *Scenario) Add(name string, weight float64, executor Executor) *Scenario {
+	s.Operations = append(s.Operations, Operation{Name: name, Weight: weight, Executor: executor})
+	return s
+}
+
+func (s *Scenario) AddFunc(name string, weight float64, executor func(ctx context.Context) error) *Scenario {
+	return s.Add(name, weight, ExecutorFunc(func(ctx context.Context, worker, iteration int) error {
+		return executor(ctx)
+	}))
+}
+
+// Setup tears down the operations already set up if a later one fails, as
+// a disabled worker is not torn down.
+func (s *Scenario) Setup(worker int) error {
+	for i, op := range s.Operations {
+		if err := op.Executor.Setup(worker); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				s.Operations[j].Executor.Teardown(worker)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Do runs one operation picked by weight, without per-operation stats. Use
+// Calculator.BenchmarkScenario to get those.
+func (s *Scenario) Do(ctx context.Context, worker, iteration int) error {
+	var sum float64
+	for _, op := range s.Operations {
+		if op.Weight > 0 {
+			sum += op.Weight
+		}
+	}
+	v := rand.Float64() * sum
+	for _, op := range s.Operations {
+		if op.Weight <= 0 {
+			continue
+		}
+		if v < op.Weight {
+			return op.Executor.Do(ctx, worker, iteration)
+		}
+		v -= op.Weight
+	}
+	return s.Operations[len(s.Operations)-1].Executor.Do(ctx, worker, iteration)
+}
+
+func (s *Scenario) Teardown(worker int) error {
+	var ret error
+	for _, op := range s.Operations {
+		if err := op.Executor.Teardown(worker); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	return ret
+}
+
+func (c *Calculator) BenchmarkScenario(ctx context.Context, opt BenchmarkOptions, scenario *Scenario) {
+	if len(scenario.Operations) == 0 {
+		return
+	}
+	c.benchmark(ctx, opt, scenario, newPicker(scenario, opt.maxConcurrent()))
+}
+
+// picker selects operations by weight. Every worker has its own random
+// source so picking never contends.
+type picker struct {
+	scenario   *Scenario
+	cumulative []float64
+	rnds       []*rand.Rand
+}
+
+func newPicker(scenario *Scenario, workers int) *picker {
+	if workers <= 0 {
+		workers = 1
+	}
+	p := &picker{
+		scenario:   scenario,
+		cumulative: make([]float64, len(scenario.Operations)),
+		rnds:       make([]*rand.Rand, workers),
+	}
+	var sum float64
+	for i, op := range scenario.Operations {
+		if op.Weight > 0 {
+			sum += op.Weight
+		}
+		p.cumulative[i] = sum
+	}
+	seed := time.Now().UnixNano()
+	for i := range p.rnds {
+		p.rnds[i] = rand.New(rand.NewSource(seed + int64(i)))
+	}
+	return p
+}
+
+func (p *picker) pick(worker int) int {
+	total := p.cumulative[len(p.cumulative)-1]
+	if total <= 0 {
+		return p.rnds[worker].Intn(len(p.cumulative))
+	}
+	v := p.rnds[worker].Float64() * total
+	return sort.Search(len(p.cumulative), func(i int) bool {
+		return p.cumulative[i] > v
+	})
+}
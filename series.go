@@ -0,0 +1,165 @@
+package perf
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+type Number interface {
+	~int | ~int32 | ~int64 | ~uint | ~uint32 | ~uint64 | ~float32 | ~float64
+}
+
+// Series is a metric sampled over time. Times is either empty or as long as
+// Values. All statistics of an empty Series are zero.
+type Series[T Number] struct {
+	Values []T         `json:"values"`
+	Times  []time.Time `json:"times,omitempty"`
+}
+
+func NewSeries[T Number](values []T, times []time.Time) *Series[T] {
+	return &Series[T]{Values: values, Times: times}
+}
+
+// seriesOf builds a Series from the samples of one collected metric.
+func seriesOf[S any, T Number](samples []S, times []time.Time, get func(S) T) *Series[T] {
+	s := &Series[T]{Values: make([]T, len(samples)), Times: times}
+	for i, v := range samples {
+		s.Values[i] = get(v)
+	}
+	if len(s.Times) != len(s.Values) {
+		s.Times = nil
+	}
+	return s
+}
+
+func (s *Series[T]) Len() int {
+	return len(s.Values)
+}
+
+func (s *Series[T]) Min() T {
+	var ret T
+	for i, v := range s.Values {
+		if i == 0 || v < ret {
+			ret = v
+		}
+	}
+	return ret
+}
+
+func (s *Series[T]) Max() T {
+	var ret T
+	for i, v := range s.Values {
+		if i == 0 || v > ret {
+			ret = v
+		}
+	}
+	return ret
+}
+
+func (s *Series[T]) Avg() float64 {
+	if len(s.Values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range s.Values {
+		sum += float64(v)
+	}
+	return sum / float64(len(s.Values))
+}
+
+func (s *Series[T]) Stddev() float64 {
+	if len(s.Values) == 0 {
+		return 0
+	}
+	avg := s.Avg()
+	var sum float64
+	for _, v := range s.Values {
+		d := float64(v) - avg
+		sum += d * d
+	}
+	return math.Sqrt(sum / float64(len(s.Values)))
+}
+
+// Percentile returns the sample below which percentile (0-100) percent of
+// the samples fall.
+func (s *Series[T]) Percentile(percentile float64) T {
+	if len(s.Values) == 0 {
+		var zero T
+		return zero
+	}
+	values := append([]T{}, s.Values...)
+	sort.Slice(values, func(i, j int) bool {
+		return values[i] < values[j]
+	})
+	i := int(math.Ceil(percentile/100*float64(len(values)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(values) {
+		i = len(values) - 1
+	}
+	return values[i]
+}
+
+// Trim drops the first head and the last tail samples, e.g. to leave out
+// ramp-up and ramp-down. When fewer samples are left both are reduced
+// alternately, so at least one sample survives a non-empty Series.
+func (s *Series[T]) Trim(head, tail int) *Series[T] {
+	n := len(s.Values)
+	if n == 0 {
+		return &Series[T]{}
+	}
+	if head < 0 {
+		head = 0
+	}
+	if tail < 0 {
+		tail = 0
+	}
+	for head+tail >= n {
+		if head > 0 {
+			head--
+		}
+		if tail > 0 && head+tail >= n {
+			tail--
+		}
+	}
+	t := &Series[T]{Values: s.Values[head : n-tail]}
+	if len(s.Times) == n {
+		t.Times = s.Times[head : n-tail]
+	}
+	return t
+}
+
+// Between returns the samples taken between begin and end inclusive.
+func (s *Series[T]) Between(begin, end time.Time) *Series[T] {
+	values, times := samplesBetween(s.Values, s.Times, begin, end)
+	return &Series[T]{Values: values, Times: times}
+}
+
+// Rate treats s as a cumulative counter and returns its per-second rate
+// per sampling interval. It needs Times.
+func (s *Series[T]) Rate() *CounterRate {
+	r := &CounterRate{Series: &Series[float64]{}}
+	if len(s.Values) < 2 || len(s.Values) != len(s.Times) {
+		return r
+	}
+	var total float64
+	for i := 1; i < len(s.Values); i++ {
+		d := s.Times[i].Sub(s.Times[i-1]).Seconds()
+		if d <= 0 {
+			continue
+		}
+		// a counter going backwards was reset, count from zero.
+		delta := float64(s.Values[i])
+		if s.Values[i] >= s.Values[i-1] {
+			delta = float64(s.Values[i] - s.Values[i-1])
+		}
+		r.Values = append(r.Values, delta/d)
+		r.Times = append(r.Times, s.Times[i])
+		total += delta
+	}
+	r.Total = uint64(total)
+	r.Elapsed = s.Times[len(s.Times)-1].Sub(s.Times[0])
+	return r
+}
@@ -0,0 +1,187 @@
+package perf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Session owns a Calculator and a PSCounter for one run. Warmup only runs
+// the Calculator, Run and Benchmark sample the PSCounter exactly while the
+// benchmark is measured, leaving out warmup, Setup and Teardown.
+type Session struct {
+	Name           string
+	Calculator     *Calculator
+	PSCounter      *PSCounter
+	PSCountOptions PSCountOptions
+}
+
+// NewSession samples process pid, the current process if 0. CPU and memory
+// are counted if opt counts nothing.
+func NewSession(name string, pid int, opt PSCountOptions) (*Session, error) {
+	p, err := NewPSCounter(pid)
+	if err != nil {
+		return nil, err
+	}
+	if !opt.CountCPU && !opt.CountMEM && !opt.CountIO && !opt.CountNET && !opt.CountGoroutine && !opt.CountRuntime {
+		opt.CountCPU = true
+		opt.CountMEM = true
+	}
+	return &Session{
+		Name:           name,
+		Calculator:     NewCalculator(name),
+		PSCounter:      p,
+		PSCountOptions: opt,
+	}, nil
+}
+
+func (s *Session) Warmup(concurrent, times int, executor func() error) {
+	s.Calculator.Warmup(concurrent, times, executor)
+}
+
+func (s *Session) WarmupDuration(concurrent int, duration time.Duration, executor func() error) {
+	s.Calculator.WarmupDuration(concurrent, duration, executor)
+}
+
+func (s *Session) Run(ctx context.Context, opt BenchmarkOptions, executor Executor) *Report {
+	c := s.Calculator
+	c.beforeRun = func() {
+		s.PSCounter.Start(s.PSCountOptions)
+	}
+	c.afterRun = s.PSCounter.Stop
+	c.BenchmarkExecutor(ctx, opt, executor)
+	c.beforeRun, c.afterRun = nil, nil
+	return newReport(s)
+}
+
+func (s *Session) Benchmark(opt BenchmarkOptions, executor func() error) *Report {
+	return s.Run(context.Background(), opt, ExecutorFunc(func(ctx context.Context, worker, iteration int) error {
+		return executor()
+	}))
+}
+
+// Report combines a Calculator's result with the resource usage sampled
+// during the same window. Begin and End are the Calculator's measured
+// window. CPUAvg is the CPU time the process used over that window in
+// percent, CPUMax the highest per-interval usage. TPSPerCPU is TPS divided
+// by CPUAvg, RSSPeak the highest RSS seen under load.
+type Report struct {
+	Name      string     `json:"name"`
+	Begin     time.Time  `json:"begin"`
+	End       time.Time  `json:"end"`
+	Result    *Result    `json:"result"`
+	CPUAvg    float64    `json:"cpu_avg"`
+	CPUMax    float64    `json:"cpu_max"`
+	RSSAvg    uint64     `json:"rss_avg"`
+	RSSPeak   uint64     `json:"rss_peak"`
+	TPSPerCPU float64    `json:"tps_per_cpu"`
+	PSCounter *PSCounter `json:"-"`
+}
+
+func newReport(s *Session) *Report {
+	c, p := s.Calculator, s.PSCounter
+	r := &Report{
+		Name:      s.Name,
+		Begin:     c.Begin,
+		End:       c.Begin.Add(c.Used),
+		Result:    c.Result(),
+		CPUMax:    p.CPU().Max(),
+		RSSAvg:    uint64(p.MEMRSS().Avg()),
+		RSSPeak:   p.MEMRSS().Max(),
+		PSCounter: p,
+	}
+	// Start and Stop sample the CPU times right around the window.
+	if t := p.CPUTime(); t.Len() > 1 {
+		n := t.Len()
+		if d := t.Times[n-1].Sub(t.Times[0]).Seconds(); d > 0 {
+			r.CPUAvg = (t.Values[n-1] - t.Values[0]) / d * 100
+		}
+	}
+	if r.CPUAvg > 0 {
+		r.TPSPerCPU = float64(r.Result.TPS) / r.CPUAvg
+	}
+	return r
+}
+
+// MarshalJSON adds the PSCounter samples as "resources".
+func (r *Report) MarshalJSON() ([]byte, error) {
+	type report Report
+	v := struct {
+		*report
+		Resources psResult `json:"resources"`
+	}{report: (*report)(r)}
+	if r.PSCounter != nil {
+		v.Resources = r.PSCounter.psResult
+	}
+	return json.Marshal(v)
+}
+
+func (r *Report) Json() string {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func (r *Report) String() string {
+	s := fmt.Sprintf("%v\n%v", r.Result.Calculator().String(), r.resources())
+	if r.Result.Env != nil {
+		s += "\n" + r.Result.Env.String()
+	}
+	return s
+}
+
+func (r *Report) resources() string {
+	return fmt.Sprintf(`CPU AVG  : %.2f%%
+CPU MAX  : %.2f%%
+RSS AVG  : %v
+RSS PEAK : %v
+TPS/CPU%% : %.2f`,
+		r.CPUAvg,
+		r.CPUMax,
+		I2MemString(r.RSSAvg),
+		I2MemString(r.RSSPeak),
+		r.TPSPerCPU)
+}
+
+// Markdown renders the report as a two-column table.
+func (r *Report) Markdown() string {
+	res := r.Result
+	t := NewTable()
+	t.SetTitle([]string{"Metric", "Value"})
+	t.AddRow([]string{"Total", fmt.Sprintf("%v", res.Total)})
+	t.AddRow([]string{"Success", fmt.Sprintf("%v", res.Success)})
+	t.AddRow([]string{"Failed", fmt.Sprintf("%v", res.Failed)})
+	t.AddRow([]string{"TPS", fmt.Sprintf("%v", res.TPS)})
+	t.AddRow([]string{"Used", I2TimeString(int64(res.Used))})
+	t.AddRow([]string{"Min", I2TimeString(res.Min)})
+	t.AddRow([]string{"Avg", I2TimeString(res.Avg)})
+	t.AddRow([]string{"Max", I2TimeString(res.Max)})
+	for _, p := range res.Percentiles {
+		t.AddRow([]string{fmt.Sprintf("TP%v", p.Percent), I2TimeString(p.Value)})
+	}
+	t.AddRow([]string{"CPU Avg", fmt.Sprintf("%.2f%%", r.CPUAvg)})
+	t.AddRow([]string{"CPU Max", fmt.Sprintf("%.2f%%", r.CPUMax)})
+	t.AddRow([]string{"RSS Avg", I2MemString(r.RSSAvg)})
+	t.AddRow([]string{"RSS Peak", I2MemString(r.RSSPeak)})
+	t.AddRow([]string{"TPS/CPU%", fmt.Sprintf("%.2f", r.TPSPerCPU)})
+	s := fmt.Sprintf("## %v\n\n%v", r.Name, t.Markdown())
+	if env := r.Result.Env; env != nil {
+		et := NewTable()
+		et.SetTitle([]string{"Environment", "Value"})
+		et.AddRow([]string{"Hostname", env.Hostname})
+		et.AddRow([]string{"OS/Arch", env.GOOS + "/" + env.GOARCH})
+		et.AddRow([]string{"Kernel", env.Kernel})
+		et.AddRow([]string{"CPU", env.CPUModel})
+		et.AddRow([]string{"Cores", fmt.Sprintf("%v physical, %v logical", env.PhysicalCores, env.NumCPU)})
+		et.AddRow([]string{"GOMAXPROCS", fmt.Sprintf("%v", env.GOMAXPROCS)})
+		et.AddRow([]string{"Memory", I2MemString(env.TotalMemory)})
+		et.AddRow([]string{"Go", env.GoVersion})
+		et.AddRow([]string{"Commit", env.Commit})
+		et.AddRow([]string{"Timestamp", env.Timestamp.Format(time.RFC3339)})
+		s += "\n" + et.Markdown()
+	}
+	return s
+}
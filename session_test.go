@@ -0,0 +1,39 @@
+package perf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSessionReportWindow(t *testing.T) {
+	s, err := NewSession("session", 0, PSCountOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a run much shorter than the default 1s sampling interval.
+	begin := time.Now()
+	r := s.Run(context.Background(), BenchmarkOptions{Concurrent: 1, Duration: 300 * time.Millisecond}, ExecutorFunc(func(ctx context.Context, worker, iteration int) error {
+		for end := time.Now().Add(time.Millisecond); time.Now().Before(end); {
+		}
+		return nil
+	}))
+	if used := time.Since(begin); used > time.Second {
+		t.Fatalf("Run took %v, Stop waited for the sampling interval", used)
+	}
+
+	c := s.Calculator
+	if !r.Begin.Equal(c.Begin) || r.End.Sub(r.Begin) != c.Used {
+		t.Fatalf("report window %v..%v, calculator %v for %v", r.Begin, r.End, c.Begin, c.Used)
+	}
+	// one busy worker is about one core.
+	if r.CPUAvg < 50 || r.CPUAvg > 150 {
+		t.Fatalf("CPUAvg %.2f%%, want about 100%%", r.CPUAvg)
+	}
+	if r.CPUMax <= 0 || r.RSSPeak == 0 || r.RSSAvg == 0 {
+		t.Fatalf("CPUMax %.2f%%, RSSPeak %v, RSSAvg %v", r.CPUMax, r.RSSPeak, r.RSSAvg)
+	}
+	if r.TPSPerCPU <= 0 {
+		t.Fatalf("TPSPerCPU %v", r.TPSPerCPU)
+	}
+}
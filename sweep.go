@@ -0,0 +1,147 @@
+package perf
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type SweepOptions struct {
+	// Exactly one of Concurrents and Rates is swept, Rates if both are set.
+	// Every level runs with Options, whose Concurrent is the worker pool of
+	// rate levels, 100 if zero. Percents defaults to 50 and 99.
+	Concurrents []int
+	Rates       []float64
+	Options     BenchmarkOptions
+	Cooldown    time.Duration
+
+	// PSCounter, if set, is started and stopped around every level, so
+	// each level reports the CPU and memory it used. CPU and memory are
+	// always counted.
+	PSCounter      *PSCounter
+	PSCountOptions PSCountOptions
+}
+
+type SweepLevel struct {
+	Concurrent int         `json:"concurrent"`
+	Rate       float64     `json:"rate,omitempty"`
+	Result     *Calculator `json:"-"`
+	CPUAvg     float64     `json:"cpu_avg"`
+	CPUMax     float64     `json:"cpu_max"`
+	MEMRSSAvg  uint64      `json:"mem_rss_avg"`
+	MEMRSSMax  uint64      `json:"mem_rss_max"`
+}
+
+type Sweep struct {
+	Name     string        `json:"name"`
+	Levels   []*SweepLevel `json:"levels"`
+	Env      *Environment  `json:"env"`
+	percents []int
+	counted  bool
+}
+
+// RunSweep runs executor once per level of opt.Concurrents or opt.Rates.
+func RunSweep(ctx context.Context, name string, opt SweepOptions, executor Executor) *Sweep {
+	if len(opt.Options.Percents) == 0 {
+		opt.Options.Percents = []int{50, 99}
+	}
+
+	var levels []*SweepLevel
+	if len(opt.Rates) > 0 {
+		if opt.Options.Concurrent <= 0 {
+			opt.Options.Concurrent = 100
+		}
+		for _, rate := range opt.Rates {
+			levels = append(levels, &SweepLevel{Concurrent: opt.Options.Concurrent, Rate: rate})
+		}
+	} else {
+		for _, n := range opt.Concurrents {
+			levels = append(levels, &SweepLevel{Concurrent: n})
+		}
+	}
+
+	popt := opt.PSCountOptions
+	popt.CountCPU = true
+	popt.CountMEM = true
+
+	s := &Sweep{Name: name, Env: CaptureEnvironment(), percents: opt.Options.Percents, counted: opt.PSCounter != nil}
+	for i, l := range levels {
+		if ctx.Err() != nil {
+			break
+		}
+		if i > 0 && opt.Cooldown > 0 {
+			time.Sleep(opt.Cooldown)
+		}
+
+		bopt := opt.Options
+		bopt.Concurrent = l.Concurrent
+		bopt.Rate = l.Rate
+		cname := fmt.Sprintf("%v-concurrent-%v", name, l.Concurrent)
+		if l.Rate > 0 {
+			cname = fmt.Sprintf("%v-rate-%v", name, l.Rate)
+		}
+		l.Result = NewCalculator(cname)
+
+		p := opt.PSCounter
+		if p != nil {
+			p.Start(popt)
+		}
+		l.Result.BenchmarkExecutor(ctx, bopt, executor)
+		if p != nil {
+			p.Stop()
+			l.CPUAvg = p.CPU().Avg()
+			l.CPUMax = p.CPU().Max()
+			l.MEMRSSAvg = uint64(p.MEMRSS().Avg())
+			l.MEMRSSMax = p.MEMRSS().Max()
+		}
+		s.Levels = append(s.Levels, l)
+	}
+	return s
+}
+
+func BenchmarkSweep(name string, opt SweepOptions, executor func() error) *Sweep {
+	return RunSweep(context.Background(), name, opt, ExecutorFunc(func(ctx context.Context, worker, iteration int) error {
+		return executor()
+	}))
+}
+
+// Table renders one row per level. CPU and MEM are the average CPU usage
+// and the peak RSS sampled during the level.
+func (s *Sweep) Table() *Table {
+	rate := len(s.Levels) > 0 && s.Levels[0].Rate > 0
+	title := []string{"Concurrent"}
+	if rate {
+		title = []string{"Rate"}
+	}
+	title = append(title, "TPS")
+	for _, k := range s.percents {
+		title = append(title, fmt.Sprintf("TP%v", k))
+	}
+	title = append(title, "Failed")
+	if s.counted {
+		title = append(title, "CPU", "MEM")
+	}
+
+	t := NewTable()
+	t.SetTitle(title)
+	for _, l := range s.Levels {
+		row := []string{fmt.Sprintf("%v", l.Concurrent)}
+		if rate {
+			row = []string{fmt.Sprintf("%v", l.Rate)}
+		}
+		row = append(row, fmt.Sprintf("%v", l.Result.TPS()))
+		for _, k := range s.percents {
+			row = append(row, I2TimeString(l.Result.TPN(k)))
+		}
+		row = append(row, fmt.Sprintf("%v", l.Result.Failed))
+		if s.counted {
+			row = append(row, fmt.Sprintf("%.2f%%", l.CPUAvg), I2MemString(l.MEMRSSMax))
+		}
+		t.AddRow(row)
+	}
+	return t
+}
+
+func (s *Sweep) String() string {
+	return s.Table().Markdown()
+}
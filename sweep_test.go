@@ -0,0 +1,25 @@
+package perf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSweepRateDefaultPool(t *testing.T) {
+	s := RunSweep(context.Background(), "sweep", SweepOptions{
+		Rates:   []float64{1000},
+		Options: BenchmarkOptions{Duration: 300 * time.Millisecond},
+	}, ExecutorFunc(func(ctx context.Context, worker, iteration int) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}))
+	l := s.Levels[0]
+	if l.Concurrent != 100 {
+		t.Fatalf("rate level ran with %v workers, want 100", l.Concurrent)
+	}
+	// a single worker would top out at 200/s.
+	if l.Result.TPS() < 500 {
+		t.Fatalf("TPS %v at rate 1000", l.Result.TPS())
+	}
+}
@@ -38,33 +38,33 @@ func main() {
 	fmt.Println("-------------------------")
 	fmt.Println(psCounter.Json())
 	fmt.Println("-------------------------")
-	fmt.Println("CPUMin:", psCounter.CPUMin())
-	fmt.Println("CPUMax:", psCounter.CPUMax())
-	fmt.Println("CPUAvg:", psCounter.CPUAvg())
+	fmt.Println("CPUMin:", psCounter.CPU().Min())
+	fmt.Println("CPUMax:", psCounter.CPU().Max())
+	fmt.Println("CPUAvg:", psCounter.CPU().Avg())
 	fmt.Println("-------------------------")
-	fmt.Println("MEMRSSMin:", psCounter.MEMRSSMin())
-	fmt.Println("MEMRSSMax :", psCounter.MEMRSSMax())
-	fmt.Println("MEMRSSAvg :", psCounter.MEMRSSAvg())
+	fmt.Println("MEMRSSMin:", psCounter.MEMRSS().Min())
+	fmt.Println("MEMRSSMax :", psCounter.MEMRSS().Max())
+	fmt.Println("MEMRSSAvg :", psCounter.MEMRSS().Avg())
 	fmt.Println("-------------------------")
-	fmt.Println("MEMVMSMin:", psCounter.MEMVMSMin())
-	fmt.Println("MEMVMSMax :", psCounter.MEMVMSMax())
-	fmt.Println("MEMVMSAvg :", psCounter.MEMVMSAvg())
+	fmt.Println("MEMVMSMin:", psCounter.MEMVMS().Min())
+	fmt.Println("MEMVMSMax :", psCounter.MEMVMS().Max())
+	fmt.Println("MEMVMSAvg :", psCounter.MEMVMS().Avg())
 	fmt.Println("-------------------------")
-	fmt.Println("IOReadCountMin:", psCounter.IOReadCountMin())
-	fmt.Println("IOReadCountMax :", psCounter.IOReadCountMax())
-	fmt.Println("IOReadCountAvg :", psCounter.IOReadCountAvg())
+	fmt.Println("IOReadCountMin:", psCounter.IOReadCount().Min())
+	fmt.Println("IOReadCountMax :", psCounter.IOReadCount().Max())
+	fmt.Println("IOReadCountAvg :", psCounter.IOReadCount().Avg())
 	fmt.Println("-------------------------")
-	fmt.Println("IOReadBytesMin:", psCounter.IOReadBytesMin())
-	fmt.Println("IOReadBytesMax :", psCounter.IOReadBytesMax())
-	fmt.Println("IOReadBytesAvg :", psCounter.IOReadBytesAvg())
+	fmt.Println("IOReadBytesMin:", psCounter.IOReadBytes().Min())
+	fmt.Println("IOReadBytesMax :", psCounter.IOReadBytes().Max())
+	fmt.Println("IOReadBytesAvg :", psCounter.IOReadBytes().Avg())
 	fmt.Println("-------------------------")
-	fmt.Println("IOWriteCountMin:", psCounter.IOWriteCountMin())
-	fmt.Println("IOWriteCountMax :", psCounter.IOWriteCountMax())
-	fmt.Println("IOWriteCountAvg :", psCounter.IOWriteCountAvg())
+	fmt.Println("IOWriteCountMin:", psCounter.IOWriteCount().Min())
+	fmt.Println("IOWriteCountMax :", psCounter.IOWriteCount().Max())
+	fmt.Println("IOWriteCountAvg :", psCounter.IOWriteCount().Avg())
 	fmt.Println("-------------------------")
-	fmt.Println("IOWriteBytesMin:", psCounter.IOWriteBytesMin())
-	fmt.Println("IOWriteBytesMax :", psCounter.IOWriteBytesMax())
-	fmt.Println("IOWriteBytesAvg :", psCounter.IOWriteBytesAvg())
+	fmt.Println("IOWriteBytesMin:", psCounter.IOWriteBytes().Min())
+	fmt.Println("IOWriteBytesMax :", psCounter.IOWriteBytes().Max())
+	fmt.Println("IOWriteBytesAvg :", psCounter.IOWriteBytes().Avg())
 	fmt.Println("-------------------------")
 	table := perf.NewTable()
 	table.SetTitle([]string{"Frameworks", "TP50", "TP99", "CPU", "MEM"})
@@ -0,0 +1,171 @@
+package perf
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const DefaultMaxCV = 0.1
+
+type TrialOptions struct {
+	Trials   int
+	Cooldown time.Duration
+	// MaxCV is the coefficient of variation (stddev/mean) above which a
+	// metric is considered unstable, DefaultMaxCV if zero.
+	MaxCV float64
+}
+
+// Summary describes one metric across trials. CILow and CIHigh bound the
+// 95% confidence interval of the mean.
+type Summary struct {
+	N        int     `json:"n"`
+	Mean     float64 `json:"mean"`
+	StdDev   float64 `json:"stddev"`
+	CILow    float64 `json:"ci_low"`
+	CIHigh   float64 `json:"ci_high"`
+	CV       float64 `json:"cv"`
+	Unstable bool    `json:"unstable"`
+}
+
+type PercentileSummary struct {
+	Percent int `json:"percent"`
+	Summary
+}
+
+type Trials struct {
+	Name        string              `json:"name"`
+	Results     []*Calculator       `json:"-"`
+	TPS         Summary             `json:"tps"`
+	Percentiles []PercentileSummary `json:"percentiles"`
+	Unstable    bool                `json:"unstable"`
+	Env         *Environment        `json:"env"`
+}
+
+// RunTrials calls scenario opt.Trials times, each time with a fresh
+// Calculator, and summarizes TPS and percentiles across the runs.
+func RunTrials(name string, opt TrialOptions, scenario func(c *Calculator)) *Trials {
+	if opt.Trials <= 0 {
+		opt.Trials = 1
+	}
+	if opt.MaxCV <= 0 {
+		opt.MaxCV = DefaultMaxCV
+	}
+
+	t := &Trials{Name: name, Env: CaptureEnvironment()}
+	for i := 0; i < opt.Trials; i++ {
+		if i > 0 && opt.Cooldown > 0 {
+			time.Sleep(opt.Cooldown)
+		}
+		c := NewCalculator(fmt.Sprintf("%v-trial-%d", name, i+1))
+		scenario(c)
+		t.Results = append(t.Results, c)
+	}
+
+	tps := make([]float64, len(t.Results))
+	for i, c := range t.Results {
+		tps[i] = float64(c.TPS())
+	}
+	t.TPS = summarize(tps, opt.MaxCV)
+	t.Unstable = t.TPS.Unstable
+
+	for _, k := range t.Results[0].percents {
+		values := make([]float64, len(t.Results))
+		for i, c := range t.Results {
+			values[i] = float64(c.TPN(k))
+		}
+		s := PercentileSummary{Percent: k, Summary: summarize(values, opt.MaxCV)}
+		t.Percentiles = append(t.Percentiles, s)
+		if s.Unstable {
+			t.Unstable = true
+		}
+	}
+	return t
+}
+
+func BenchmarkTrials(name string, topt TrialOptions, opt BenchmarkOptions, executor func() error) *Trials {
+	return RunTrials(name, topt, func(c *Calculator) {
+		c.BenchmarkWithOptions(opt, executor)
+	})
+}
+
+func (t *Trials) String() string {
+	s := fmt.Sprintf("TRIALS   : %v", len(t.Results))
+	if t.Unstable {
+		s += " (unstable)"
+	}
+	s += fmt.Sprintf("\nTPS      : %.0f ± %.0f, 95%% CI [%.0f, %.0f], CV %.2f%%",
+		t.TPS.Mean, t.TPS.StdDev, t.TPS.CILow, t.TPS.CIHigh, t.TPS.CV*100)
+	if t.TPS.Unstable {
+		s += " (unstable)"
+	}
+
+	l := len("BENCHMARK")
+	for _, p := range t.Percentiles {
+		tp := fmt.Sprintf("TP%v", p.Percent)
+		for len(tp) < l {
+			tp += " "
+		}
+		// a wide CI can reach below zero, which is no latency.
+		low := math.Max(p.CILow, 0)
+		s += fmt.Sprintf("\n%v: %v ± %v, 95%% CI [%v, %v], CV %.2f%%",
+			tp,
+			I2TimeString(int64(p.Mean)),
+			I2TimeString(int64(p.StdDev)),
+			I2TimeString(int64(low)),
+			I2TimeString(int64(p.CIHigh)),
+			p.CV*100)
+		if p.Unstable {
+			s += " (unstable)"
+		}
+	}
+	return s
+}
+
+func summarize(values []float64, maxCV float64) Summary {
+	s := Summary{N: len(values)}
+	if s.N == 0 {
+		return s
+	}
+	for _, v := range values {
+		s.Mean += v
+	}
+	s.Mean /= float64(s.N)
+	s.CILow, s.CIHigh = s.Mean, s.Mean
+	if s.N < 2 {
+		return s
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += (v - s.Mean) * (v - s.Mean)
+	}
+	s.StdDev = math.Sqrt(sum / float64(s.N-1))
+	margin := tQuantile975(s.N-1) * s.StdDev / math.Sqrt(float64(s.N))
+	s.CILow = s.Mean - margin
+	s.CIHigh = s.Mean + margin
+	if s.Mean != 0 {
+		s.CV = s.StdDev / math.Abs(s.Mean)
+	}
+	s.Unstable = s.CV > maxCV
+	return s
+}
+
+// tQuantile975 is the two-sided 95% critical value of Student's t.
+func tQuantile975(df int) float64 {
+	table := []float64{
+		12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+		2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+		2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+	}
+	if df < 1 {
+		return math.Inf(1)
+	}
+	if df <= len(table) {
+		return table[df-1]
+	}
+	// Cornish-Fisher expansion around the normal quantile.
+	const z = 1.959964
+	d := float64(df)
+	return z + (z*z*z+z)/(4*d) + (5*math.Pow(z, 5)+16*z*z*z+3*z)/(96*d*d)
+}
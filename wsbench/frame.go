@@ -0,0 +1,206 @@
+package wsbench
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	// MaxMessageSize bounds a message read by the client or the echo server.
+	MaxMessageSize = 64 << 20
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var (
+	ErrClosed          = errors.New("websocket closed")
+	ErrMessageTooLarge = errors.New("websocket message too large")
+)
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// appendFrame appends a single final frame to buf. Client frames must be
+// masked, server frames must not.
+func appendFrame(buf []byte, op byte, payload []byte, mask []byte) []byte {
+	buf = append(buf, 0x80|op)
+
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+	n := len(payload)
+	switch {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(n))
+	default:
+		buf = append(buf, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(n))
+	}
+
+	if mask == nil {
+		return append(buf, payload...)
+	}
+	buf = append(buf, mask[:4]...)
+	begin := len(buf)
+	buf = append(buf, payload...)
+	maskBytes(buf[begin:], mask)
+	return buf
+}
+
+func maskBytes(b []byte, mask []byte) {
+	for i := range b {
+		b[i] ^= mask[i&3]
+	}
+}
+
+type frame struct {
+	fin     bool
+	op      byte
+	payload []byte
+}
+
+// readFrame reads one frame into buf, reusing its capacity, and unmasks the
+// payload if needed.
+func readFrame(r *bufio.Reader, buf []byte) (frame, []byte, error) {
+	var f frame
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:2]); err != nil {
+		return f, buf, err
+	}
+	f.fin = head[0]&0x80 != 0
+	f.op = head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		return f, buf, fmt.Errorf("websocket: unexpected rsv bits")
+	}
+	masked := head[1]&0x80 != 0
+
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(r, head[:2]); err != nil {
+			return f, buf, err
+		}
+		n = uint64(binary.BigEndian.Uint16(head[:2]))
+	case 127:
+		if _, err := io.ReadFull(r, head[:8]); err != nil {
+			return f, buf, err
+		}
+		n = binary.BigEndian.Uint64(head[:8])
+	}
+	if n > MaxMessageSize {
+		return f, buf, ErrMessageTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return f, buf, err
+		}
+	}
+
+	if uint64(cap(buf)) < n {
+		buf = make([]byte, n)
+	}
+	f.payload = buf[:n]
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return f, buf, err
+	}
+	if masked {
+		maskBytes(f.payload, mask[:])
+	}
+	return f, buf, nil
+}
+
+// conn is one side of a websocket connection.
+type conn struct {
+	rw     *bufio.ReadWriter
+	client bool
+	mask   [4]byte
+	wbuf   []byte
+	rbuf   []byte
+	msg    []byte
+}
+
+func (c *conn) writeMessage(op byte, payload []byte) error {
+	var mask []byte
+	if c.client {
+		// the mask only has to be unpredictable to intermediaries, which
+		// do not matter for a benchmark, so a counter is good enough.
+		binary.BigEndian.PutUint32(c.mask[:], binary.BigEndian.Uint32(c.mask[:])+0x9E3779B9)
+		mask = c.mask[:]
+	}
+	c.wbuf = appendFrame(c.wbuf[:0], op, payload, mask)
+	if _, err := c.rw.Write(c.wbuf); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// readMessage returns the next data message, reassembling fragments and
+// answering pings. The returned payload is only valid until the next call.
+func (c *conn) readMessage() (byte, []byte, error) {
+	var op byte
+	c.msg = c.msg[:0]
+	for {
+		f, buf, err := readFrame(c.rw.Reader, c.rbuf)
+		c.rbuf = buf
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.op {
+		case opPing:
+			if err := c.writeMessage(opPong, f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeMessage(opClose, nil)
+			return 0, nil, ErrClosed
+		case opContinuation:
+			if op == 0 {
+				return 0, nil, fmt.Errorf("websocket: unexpected continuation frame")
+			}
+		case opText, opBinary:
+			if op != 0 {
+				return 0, nil, fmt.Errorf("websocket: unexpected data frame in fragmented message")
+			}
+			op = f.op
+		default:
+			return 0, nil, fmt.Errorf("websocket: unknown opcode %v", f.op)
+		}
+
+		if f.fin && len(c.msg) == 0 {
+			return op, f.payload, nil
+		}
+		if len(c.msg)+len(f.payload) > MaxMessageSize {
+			return 0, nil, ErrMessageTooLarge
+		}
+		c.msg = append(c.msg, f.payload...)
+		if f.fin {
+			return op, c.msg, nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package wsbench
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// EchoHandler upgrades the request to a websocket and echoes every message
+// back with the same opcode until the client closes the connection.
+func EchoHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	nc, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer nc.Close()
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	c := &conn{rw: rw}
+	for {
+		op, msg, err := c.readMessage()
+		if err != nil {
+			return
+		}
+		if err := c.writeMessage(op, msg); err != nil {
+			return
+		}
+	}
+}
+
+type connKey struct{}
+
+// EchoServer is an http.Server running EchoHandler on its own listener.
+// Unlike http.Server it also closes the hijacked websockets on Close.
+type EchoServer struct {
+	ln    net.Listener
+	srv   *http.Server
+	mux   sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func NewEchoServer(addr string) (*EchoServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &EchoServer{ln: ln, conns: map[net.Conn]struct{}{}}
+	s.srv = &http.Server{
+		// hijacked connections never reach StateClosed, so they are
+		// forgotten once their handler returns.
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			EchoHandler(w, r)
+			if c, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+				s.untrack(c)
+			}
+		}),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, c)
+		},
+		// hijacked connections are not closed by Server.Close, track them
+		// so Close does not leave echo loops behind.
+		ConnState: func(c net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				s.mux.Lock()
+				s.conns[c] = struct{}{}
+				s.mux.Unlock()
+			case http.StateClosed:
+				s.untrack(c)
+			}
+		},
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.srv.Serve(ln)
+	}()
+	return s, nil
+}
+
+func (s *EchoServer) untrack(c net.Conn) {
+	s.mux.Lock()
+	delete(s.conns, c)
+	s.mux.Unlock()
+}
+
+func (s *EchoServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *EchoServer) URL() string {
+	return "ws://" + s.Addr() + "/"
+}
+
+func (s *EchoServer) Close() error {
+	err := s.srv.Close()
+	s.mux.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mux.Unlock()
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,266 @@
+package wsbench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lesismal/perf"
+	"github.com/lesismal/perf/internal/netutil"
+)
+
+const DefaultMessageSize = 64
+
+var ErrEchoMismatch = errors.New("echo mismatch")
+
+type Options struct {
+	// URL is a ws:// or wss:// url.
+	URL    string
+	Header http.Header
+	// Binary sends binary messages, text messages otherwise.
+	Binary bool
+	// MessageSize is the payload size of every message, DefaultMessageSize
+	// if zero.
+	MessageSize     int
+	DialTimeout     time.Duration
+	TLSConfig       *tls.Config
+	HistogramDigits int
+}
+
+// Executor is a perf.Executor holding one websocket per worker. Setup dials
+// and upgrades the connection and records the time in Handshake; every call
+// sends one message and waits for its echo, so the Calculator measures the
+// message round trip. A connection that failed is reopened by the worker's
+// next call.
+type Executor struct {
+	opt   Options
+	u     *url.URL
+	conns sync.Map
+
+	Handshake *perf.Histogram
+}
+
+type wsConn struct {
+	*conn
+	nc      net.Conn
+	payload []byte
+}
+
+func New(opt Options) (*Executor, error) {
+	u, err := url.Parse(opt.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("wsbench: invalid scheme: %v", u.Scheme)
+	}
+	if opt.MessageSize <= 0 {
+		opt.MessageSize = DefaultMessageSize
+	}
+	if opt.HistogramDigits <= 0 {
+		opt.HistogramDigits = perf.DefaultHistogramDigits
+	}
+	return &Executor{
+		opt:       opt,
+		u:         u,
+		Handshake: perf.NewHistogram(perf.DefaultHistogramMax, opt.HistogramDigits),
+	}, nil
+}
+
+// Benchmark creates an Executor for wopt and runs it with c. Handshakes
+// happen in Setup, outside c's numbers, so the returned Executor is the
+// only place their latency is kept.
+func Benchmark(ctx context.Context, c *perf.Calculator, opt perf.BenchmarkOptions, wopt Options) (*Executor, error) {
+	e, err := New(wopt)
+	if err != nil {
+		return nil, err
+	}
+	c.BenchmarkExecutor(ctx, opt, e)
+	return e, nil
+}
+
+func (e *Executor) Setup(worker int) error {
+	payload := make([]byte, e.opt.MessageSize)
+	rnd := mrand.New(mrand.NewSource(time.Now().UnixNano() + int64(worker)))
+	if e.opt.Binary {
+		rnd.Read(payload)
+	} else {
+		const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+		for i := range payload {
+			payload[i] = letters[rnd.Intn(len(letters))]
+		}
+	}
+
+	c := &wsConn{payload: payload}
+	if err := e.dial(c); err != nil {
+		return err
+	}
+	e.conns.Store(worker, c)
+	return nil
+}
+
+func (e *Executor) Teardown(worker int) error {
+	v, ok := e.conns.LoadAndDelete(worker)
+	if !ok {
+		return nil
+	}
+	c := v.(*wsConn)
+	if c.nc == nil {
+		return nil
+	}
+	c.nc.SetDeadline(time.Now().Add(time.Second))
+	c.writeMessage(opClose, nil)
+	return c.nc.Close()
+}
+
+func (e *Executor) Do(ctx context.Context, worker, iteration int) error {
+	v, ok := e.conns.Load(worker)
+	if !ok {
+		return fmt.Errorf("wsbench: worker %v not set up", worker)
+	}
+	c := v.(*wsConn)
+	if c.nc == nil {
+		if err := e.dial(c); err != nil {
+			return err
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+	c.nc.SetDeadline(deadline)
+	defer netutil.WatchCancel(ctx, c.nc)()
+
+	err := e.roundTrip(c)
+	if err != nil {
+		c.nc.Close()
+		c.nc = nil
+	}
+	return err
+}
+
+func (e *Executor) roundTrip(c *wsConn) error {
+	op := byte(opText)
+	if e.opt.Binary {
+		op = opBinary
+	}
+	if err := c.writeMessage(op, c.payload); err != nil {
+		return err
+	}
+	rop, msg, err := c.readMessage()
+	if err != nil {
+		return err
+	}
+	if rop != op || !bytes.Equal(msg, c.payload) {
+		return ErrEchoMismatch
+	}
+	return nil
+}
+
+func (e *Executor) dial(c *wsConn) error {
+	begin := time.Now()
+	nc, rw, err := e.handshake()
+	if err != nil {
+		return err
+	}
+	e.Handshake.Record(time.Since(begin).Nanoseconds())
+	c.nc = nc
+	c.conn = &conn{rw: rw, client: true}
+	return nil
+}
+
+func (e *Executor) handshake() (net.Conn, *bufio.ReadWriter, error) {
+	host := e.u.Host
+	if e.u.Port() == "" {
+		if e.u.Scheme == "wss" {
+			host = net.JoinHostPort(e.u.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(e.u.Hostname(), "80")
+		}
+	}
+
+	d := &net.Dialer{Timeout: e.opt.DialTimeout}
+	var nc net.Conn
+	var err error
+	if e.u.Scheme == "wss" {
+		cfg := e.opt.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{ServerName: e.u.Hostname()}
+		}
+		nc, err = tls.DialWithDialer(d, "tcp", host, cfg)
+	} else {
+		nc, err = d.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if e.opt.DialTimeout > 0 {
+		nc.SetDeadline(time.Now().Add(e.opt.DialTimeout))
+	}
+	rw, err := e.upgrade(nc)
+	if err != nil {
+		nc.Close()
+		return nil, nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	return nc, rw, nil
+}
+
+func (e *Executor) upgrade(nc net.Conn) (*bufio.ReadWriter, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	u := *e.u
+	if u.Scheme == "wss" {
+		u.Scheme = "https"
+	} else {
+		u.Scheme = "http"
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range e.opt.Header {
+		req.Header[k] = v
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	rw := bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))
+	if err := req.Write(rw); err != nil {
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(rw.Reader, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("wsbench: unexpected status: %v", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("wsbench: invalid Sec-WebSocket-Accept")
+	}
+	return rw, nil
+}
@@ -0,0 +1,162 @@
+package wsbench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lesismal/perf"
+)
+
+func TestBenchmark(t *testing.T) {
+	s, err := NewEchoServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 10, 1000 and 70000 bytes cover the 7, 16 and 64 bit frame lengths.
+	for _, size := range []int{10, 1000, 70000} {
+		for _, binary := range []bool{false, true} {
+			c := perf.NewCalculator("ws")
+			e, err := Benchmark(context.Background(), c, perf.BenchmarkOptions{
+				Concurrent: 4,
+				Times:      200,
+			}, Options{URL: s.URL(), MessageSize: size, Binary: binary})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Success != 200 || c.Failed != 0 || c.SetupFailed != 0 {
+				t.Fatalf("size %v binary %v: success %v, failed %v %v, setup failed %v",
+					size, binary, c.Success, c.Failed, c.FailedErrors, c.SetupFailed)
+			}
+			if n := e.Handshake.TotalCount(); n != 4 {
+				t.Fatalf("size %v binary %v: %v handshakes, want 4", size, binary, n)
+			}
+		}
+	}
+}
+
+func TestEchoServerForgetsClosedConns(t *testing.T) {
+	s, err := NewEchoServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c := perf.NewCalculator("ws")
+	if _, err := Benchmark(context.Background(), c, perf.BenchmarkOptions{Concurrent: 8, Times: 80}, Options{URL: s.URL()}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s.mux.Lock()
+		n := len(s.conns)
+		s.mux.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v conns still tracked after their clients closed", n)
+		}
+	}
+}
+
+func TestCancelUnblocksSilentServer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nc, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		rw.Flush()
+		// never echo, just wait for the client to go away.
+		nc.Read(make([]byte, 1))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Benchmark(ctx, perf.NewCalculator("ws"), perf.BenchmarkOptions{Concurrent: 2, Duration: time.Minute},
+			Options{URL: "ws" + strings.TrimPrefix(srv.URL, "http")})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Benchmark did not return after ctx was cancelled")
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 125, 126, 0xFFFF, 0x10000, 70000} {
+		payload := make([]byte, n)
+		for i := range payload {
+			payload[i] = byte(i * 7)
+		}
+		for _, mask := range [][]byte{nil, {1, 2, 3, 4}} {
+			b := appendFrame(nil, opBinary, payload, mask)
+			f, _, err := readFrame(bufio.NewReader(bytes.NewReader(b)), nil)
+			if err != nil {
+				t.Fatalf("len %v mask %v: %v", n, mask, err)
+			}
+			if !f.fin || f.op != opBinary || !bytes.Equal(f.payload, payload) {
+				t.Fatalf("len %v mask %v: got fin %v op %v len %v", n, mask, f.fin, f.op, len(f.payload))
+			}
+		}
+	}
+}
+
+func TestReadMessageFragmented(t *testing.T) {
+	mask := []byte{9, 8, 7, 6}
+	var in []byte
+	first := appendFrame(nil, opText, []byte("hel"), mask)
+	first[0] &^= 0x80
+	in = append(in, first...)
+	in = appendFrame(in, opPing, []byte("p"), mask)
+	middle := appendFrame(nil, opContinuation, []byte("lo, "), mask)
+	middle[0] &^= 0x80
+	in = append(in, middle...)
+	in = appendFrame(in, opContinuation, []byte("world"), mask)
+	in = appendFrame(in, opClose, nil, mask)
+
+	var out bytes.Buffer
+	c := &conn{rw: bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(in)), bufio.NewWriter(&out))}
+	op, msg, err := c.readMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op != opText || string(msg) != "hello, world" {
+		t.Fatalf("got op %v %q", op, msg)
+	}
+	if _, _, err := c.readMessage(); err != ErrClosed {
+		t.Fatalf("got %v, want ErrClosed", err)
+	}
+
+	r := bufio.NewReader(&out)
+	f, _, err := readFrame(r, nil)
+	if err != nil || f.op != opPong || string(f.payload) != "p" {
+		t.Fatalf("got op %v %q %v, want pong", f.op, f.payload, err)
+	}
+	if f, _, err = readFrame(r, nil); err != nil || f.op != opClose {
+		t.Fatalf("got op %v %v, want close", f.op, err)
+	}
+}
+
+func TestReadMessageUnexpectedContinuation(t *testing.T) {
+	in := appendFrame(nil, opContinuation, []byte("x"), nil)
+	c := &conn{rw: bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(in)), bufio.NewWriter(&bytes.Buffer{}))}
+	if _, _, err := c.readMessage(); err == nil {
+		t.Fatal("expected an error")
+	}
+}